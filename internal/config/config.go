@@ -1,20 +1,140 @@
 package config
 
 import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sync"
 )
 
 // Config holds application configuration
 type Config struct {
-	HTTPPort    int
-	UDPPort     int
-	DeviceName  string
-	DownloadDir string
+	HTTPPort    int    `json:"httpPort"`
+	UDPPort     int    `json:"udpPort"`
+	DeviceName  string `json:"deviceName"`
+	DownloadDir string `json:"downloadDir"`
+	// DeviceType is announced to peers: "desktop", "server" or "mobile"
+	DeviceType string `json:"deviceType"`
+
+	// AdminAddr is where the web interface and control API listen. It should
+	// stay on a loopback address; only HTTPPort is exposed to the LAN.
+	AdminAddr string `json:"adminAddr"`
+	// AdminHosts lists extra Host header values accepted by the admin listener
+	AdminHosts []string `json:"adminHosts"`
+	// APIToken must accompany every control API request
+	APIToken string `json:"-"`
+	// Identity signs discovery announcements; its fingerprint identifies this device
+	Identity ed25519.PrivateKey `json:"-"`
+	// TrustedPeers are paired devices whose signed announcements are marked verified
+	TrustedPeers []TrustedPeer `json:"trustedPeers"`
+	// Allow, when not empty, limits discovery and receiving to matching devices;
+	// Block hides and refuses matching devices
+	Allow []PolicyRule `json:"allow"`
+	Block []PolicyRule `json:"block"`
+
+	// Interfaces restricts discovery to interfaces matching these names or CIDRs
+	Interfaces []string `json:"interfaces"`
+	// ExcludeInterfaces skips interfaces matching these names or CIDRs
+	ExcludeInterfaces []string `json:"excludeInterfaces"`
+
+	// Peers are manually added devices (host, host:port or [ipv6]:port) that
+	// broadcast can't reach, e.g. on other subnets or over VPN
+	Peers []string `json:"peers"`
+	// Groups are named sets of devices, usable as send targets
+	Groups []Group `json:"groups"`
+
+	// WatchFolders are sent automatically to their target. Folders are polled
+	// every WatchPollSeconds, and a file is sent once it hasn't changed for
+	// WatchSettleSeconds, so files still being written are not sent early.
+	WatchFolders       []WatchFolder `json:"watchFolders"`
+	WatchPollSeconds   int           `json:"watchPollSeconds"`
+	WatchSettleSeconds int           `json:"watchSettleSeconds"`
+	// SyncFolders are folders other devices may sync into
+	SyncFolders []SyncFolder `json:"syncFolders"`
+
+	// Sweep probes every address of the local /24 (or SweepCIDRs) during
+	// discovery, for networks that filter broadcast and multicast
+	Sweep      bool     `json:"sweep"`
+	SweepCIDRs []string `json:"sweepCidrs"`
+	// SweepMode is "udp" (unicast discovery datagrams) or "http" (GET /api/info)
+	SweepMode string `json:"sweepMode"`
+	// SweepRate limits probes per second, SweepConcurrency parallel HTTP probes
+	SweepRate        int `json:"sweepRate"`
+	SweepConcurrency int `json:"sweepConcurrency"`
+
+	// DropPIN, when set, must be entered on the public /drop page
+	DropPIN string `json:"dropPin"`
+	// DropRequireConsent asks the host to accept each /drop upload
+	DropRequireConsent bool `json:"dropRequireConsent"`
+
+	// DiscoveryRate limits discovery responses per second to each source
+	DiscoveryRate float64 `json:"discoveryRate"`
+	// RequestRate limits HTTP requests per second from each LAN address
+	RequestRate float64 `json:"requestRate"`
+	// MaxTransfers caps concurrent incoming transfers in total and per peer
+	MaxTransfers        int `json:"maxTransfers"`
+	MaxTransfersPerPeer int `json:"maxTransfersPerPeer"`
+	// BanThreshold malformed packets or failed authentications within a
+	// minute ban the source for BanMinutes
+	BanThreshold int `json:"banThreshold"`
+	BanMinutes   int `json:"banMinutes"`
+
+	// MaxFileSizeMB and MaxBatchSizeMB limit incoming files and transfers,
+	// PeerQuotaMB what each peer may send within 24 hours (0 = unlimited)
+	MaxFileSizeMB  int `json:"maxFileSizeMB"`
+	MaxBatchSizeMB int `json:"maxBatchSizeMB"`
+	PeerQuotaMB    int `json:"peerQuotaMB"`
+	// MinFreeSpaceMB is kept free in the download directory
+	MinFreeSpaceMB int `json:"minFreeSpaceMB"`
+
+	// AllowTypes, DenyTypes and QuarantineTypes screen received files by
+	// extension (".exe") or sniffed MIME type ("application/x-*")
+	AllowTypes      []string `json:"allowTypes"`
+	DenyTypes       []string `json:"denyTypes"`
+	QuarantineTypes []string `json:"quarantineTypes"`
+	// ScanCommand scans each received file before it is moved into
+	// DownloadDir, e.g. ["clamdscan", "--no-summary", "{file}"]
+	ScanCommand        []string `json:"scanCommand"`
+	ScanTimeoutSeconds int      `json:"scanTimeoutSeconds"`
+
+	// TransferStreams is the number of connections a large file is sent over
+	// (0 = tuned automatically), ParallelFiles how many files of a batch are
+	// sent at once
+	TransferStreams int `json:"transferStreams"`
+	ParallelFiles   int `json:"parallelFiles"`
+
+	// Upload and download limits in KB per second, in total and for each
+	// peer (0 = unlimited)
+	UploadLimitKBps       int `json:"uploadLimitKBps"`
+	DownloadLimitKBps     int `json:"downloadLimitKBps"`
+	PeerUploadLimitKBps   int `json:"peerUploadLimitKBps"`
+	PeerDownloadLimitKBps int `json:"peerDownloadLimitKBps"`
+
+	mutex sync.RWMutex
+}
+
+// Dir returns the directory holding the configuration file and other state
+func Dir() string {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		configDir = "."
+	}
+	return filepath.Join(configDir, "localsend")
+}
+
+// Path returns the location of the configuration file
+func Path() string {
+	return filepath.Join(Dir(), "config.json")
 }
 
-// Load returns the default configuration
+// Load returns the default configuration, overridden by the configuration file if present
 func Load() *Config {
 	// Get user's home directory
 	homeDir, err := os.UserHomeDir()
@@ -22,31 +142,149 @@ func Load() *Config {
 		homeDir = "."
 	}
 
-	// Create download directory path
-	downloadDir := filepath.Join(homeDir, "Downloads", "LocalSend")
+	// Get device name (hostname or default)
+	deviceName, err := os.Hostname()
+	if err != nil {
+		deviceName = "Unknown-" + runtime.GOOS
+	}
+
+	cfg := &Config{
+		HTTPPort:            8080,
+		UDPPort:             8888,
+		DeviceName:          deviceName,
+		DownloadDir:         filepath.Join(homeDir, "Downloads", "LocalSend"),
+		DeviceType:          "desktop",
+		AdminAddr:           "127.0.0.1:8081",
+		SweepMode:           "udp",
+		SweepRate:           200,
+		SweepConcurrency:    32,
+		DropRequireConsent:  true,
+		DiscoveryRate:       2,
+		RequestRate:         50,
+		MaxTransfers:        16,
+		MaxTransfersPerPeer: 4,
+		BanThreshold:        20,
+		BanMinutes:          10,
+		MinFreeSpaceMB:      256,
+		ScanTimeoutSeconds:  120,
+		ParallelFiles:       4,
+		WatchPollSeconds:    2,
+		WatchSettleSeconds:  5,
+	}
+
+	// Apply settings from the configuration file on top of the defaults
+	data, err := os.ReadFile(Path())
+	if err == nil {
+		if err := json.Unmarshal(data, cfg); err != nil {
+			fmt.Printf("Error reading config file %s: %v\n", Path(), err)
+		}
+	}
+	cfg.checkSyncFolders()
+
+	// Load the control API token, falling back to one valid for this run only
+	cfg.APIToken, err = LoadToken()
+	if err != nil {
+		fmt.Printf("Error loading API token: %v\n", err)
+		b := make([]byte, 32)
+		rand.Read(b)
+		cfg.APIToken = hex.EncodeToString(b)
+	}
+
+	// Load the identity key, falling back to one valid for this run only
+	cfg.Identity, err = LoadIdentity()
+	if err != nil {
+		fmt.Printf("Error loading identity key: %v\n", err)
+		_, cfg.Identity, _ = ed25519.GenerateKey(rand.Reader)
+	}
 
 	// Create download directory if it doesn't exist
-	if err := os.MkdirAll(downloadDir, 0755); err != nil {
-		downloadDir = "./downloads" // fallback to current directory
-		os.MkdirAll(downloadDir, 0755)
+	if err := os.MkdirAll(cfg.DownloadDir, 0755); err != nil {
+		cfg.DownloadDir = "./downloads" // fallback to current directory
+		os.MkdirAll(cfg.DownloadDir, 0755)
 	}
 
-	// Get device name (hostname or default)
-	deviceName, err := os.Hostname()
+	return cfg
+}
+
+// View calls fn with the configuration locked against updates. Settings
+// that can change at runtime must be read through View.
+func (c *Config) View(fn func(c *Config)) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	fn(c)
+}
+
+// Update applies fn to the configuration and writes the settings it changed
+// to the configuration file, leaving the others as the user wrote them. fn
+// must replace slices rather than modify them, as readers may still hold them.
+func (c *Config) Update(fn func(c *Config)) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	before, err := c.fields()
 	if err != nil {
-		deviceName = "Unknown-" + runtime.GOOS
+		return fmt.Errorf("failed to marshal config: %v", err)
+	}
+	fn(c)
+	after, err := c.fields()
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %v", err)
 	}
 
-	return &Config{
-		HTTPPort:    8080,
-		UDPPort:     8888,
-		DeviceName:  deviceName,
-		DownloadDir: downloadDir,
+	saved := make(map[string]json.RawMessage)
+	data, err := os.ReadFile(Path())
+	if err == nil {
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return fmt.Errorf("failed to read config file: %v", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	for key, value := range after {
+		if !bytes.Equal(value, before[key]) {
+			saved[key] = value
+		}
 	}
+
+	data, err = json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %v", err)
+	}
+	if err := os.MkdirAll(Dir(), 0700); err != nil {
+		return fmt.Errorf("failed to create config directory: %v", err)
+	}
+	if err := os.WriteFile(Path(), data, 0600); err != nil {
+		return fmt.Errorf("failed to write config file: %v", err)
+	}
+	return nil
+}
+
+// fields returns the settings as they appear in the configuration file
+func (c *Config) fields() (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(data, &fields)
+	return fields, err
+}
+
+// AdminURL returns the browser URL of the admin listener, using localhost for
+// wildcard hosts
+func (c *Config) AdminURL() string {
+	host, port, err := net.SplitHostPort(c.AdminAddr)
+	if err != nil {
+		return "http://" + c.AdminAddr
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port)
 }
 
 // GetLocalIP returns the local IP address (placeholder for now)
 func GetLocalIP() string {
 	// This will be implemented in the discovery package
 	return "localhost"
-}
\ No newline at end of file
+}
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Group is a named set of devices to send to at once. Members are device
+// IDs, so a device stays in the group when its address changes.
+type Group struct {
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+}
+
+// Slug is how the group is referred to as a target, e.g. "@qa-rigs" for
+// "QA rigs"
+func (g Group) Slug() string {
+	return GroupSlug(g.Name)
+}
+
+// GroupSlug lowercases name and joins its words with dashes
+func GroupSlug(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+	return b.String()
+}
+
+// FindGroup returns the group named by ref, a group name or slug with or
+// without a leading "@". It must not be called from Update.
+func (c *Config) FindGroup(ref string) (Group, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	slug := GroupSlug(strings.TrimPrefix(strings.TrimSpace(ref), "@"))
+	for _, group := range c.Groups {
+		if group.Slug() == slug {
+			return group, true
+		}
+	}
+	return Group{}, false
+}
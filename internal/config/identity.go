@@ -0,0 +1,67 @@
+package config
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TrustedPeer is a device whose key the user has paired with
+type TrustedPeer struct {
+	Name        string `json:"name"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// PolicyRule matches devices for the allow and block lists. Every field that
+// is set must match.
+type PolicyRule struct {
+	ID          string `json:"id,omitempty"`          // device ID, a fingerprint prefix
+	Fingerprint string `json:"fingerprint,omitempty"` // full key fingerprint
+	IP          string `json:"ip,omitempty"`          // address or CIDR
+	Name        string `json:"name,omitempty"`        // name pattern, e.g. "build-*"
+}
+
+// ValidDeviceID reports whether id can name a device: a fingerprint prefix
+// of at least 16 hex characters, so that it can't match many keys by chance
+func ValidDeviceID(id string) bool {
+	b, err := hex.DecodeString(id)
+	return err == nil && len(id) >= 16 && len(b) <= 32
+}
+
+// IdentityPath returns the location of the device's private key
+func IdentityPath() string {
+	return filepath.Join(Dir(), "identity.key")
+}
+
+// LoadIdentity returns the persistent signing key of this device, creating
+// it on first use
+func LoadIdentity() (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(IdentityPath())
+	if err == nil {
+		seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("invalid identity key in %s", IdentityPath())
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read identity key: %v", err)
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate identity key: %v", err)
+	}
+
+	if err := os.MkdirAll(Dir(), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create config directory: %v", err)
+	}
+	if err := os.WriteFile(IdentityPath(), []byte(hex.EncodeToString(key.Seed())+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write identity key: %v", err)
+	}
+
+	return key, nil
+}
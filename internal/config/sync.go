@@ -0,0 +1,63 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// SyncFolder is a folder other devices may keep in sync with one of theirs,
+// e.g. with "localsend sync ./assets --to designer-pc:assets"
+type SyncFolder struct {
+	// Name is how senders refer to the folder
+	Name string `json:"name"`
+	// Path defaults to a folder called Name in the download directory
+	Path string `json:"path"`
+	// From, when not empty, lists the device IDs allowed to sync into the
+	// folder; otherwise only paired devices may
+	From []string `json:"from"`
+}
+
+// FindSyncFolder returns the sync folder called name
+func (c *Config) FindSyncFolder(name string) (SyncFolder, bool) {
+	for _, folder := range c.SyncFolders {
+		if folder.Name != name {
+			continue
+		}
+		if folder.Path == "" {
+			folder.Path = filepath.Join(c.DownloadDir, folder.Name)
+		}
+		return folder, true
+	}
+	return SyncFolder{}, false
+}
+
+// Accepts reports whether the device with the key fingerprint may sync into
+// the folder. Devices that don't sign their requests never may, and entries
+// of From that aren't valid device IDs match no device.
+func (f SyncFolder) Accepts(fingerprint string, paired bool) bool {
+	if fingerprint == "" {
+		return false
+	}
+	if len(f.From) == 0 {
+		return paired
+	}
+	for _, id := range f.From {
+		id = strings.ToLower(strings.TrimSpace(id))
+		if ValidDeviceID(id) && strings.HasPrefix(fingerprint, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSyncFolders warns about entries of From that match no device
+func (c *Config) checkSyncFolders() {
+	for _, folder := range c.SyncFolders {
+		for _, id := range folder.From {
+			if !ValidDeviceID(strings.ToLower(strings.TrimSpace(id))) {
+				fmt.Printf("Ignoring invalid device ID %q in sync folder %q\n", id, folder.Name)
+			}
+		}
+	}
+}
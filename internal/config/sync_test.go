@@ -0,0 +1,33 @@
+package config
+
+import "testing"
+
+func TestSyncFolderAccepts(t *testing.T) {
+	const fingerprint = "3f9a0c5e8b1d7e42a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718"
+
+	tests := []struct {
+		name   string
+		from   []string
+		signer string
+		paired bool
+		want   bool
+	}{
+		{"unsigned", []string{"3f9a0c5e8b1d7e42"}, "", true, false},
+		{"paired without from", nil, fingerprint, true, true},
+		{"unpaired without from", nil, fingerprint, false, false},
+		{"listed", []string{"3F9A0C5E8B1D7E42"}, fingerprint, false, true},
+		{"full fingerprint", []string{fingerprint}, fingerprint, false, true},
+		{"not listed", []string{"8b1d7e423f9a0c5e"}, fingerprint, true, false},
+		{"empty entry", []string{""}, fingerprint, true, false},
+		{"short entry", []string{"3f"}, fingerprint, false, false},
+		{"not hex", []string{"3f9a0c5e8b1d7e4z"}, fingerprint, false, false},
+		{"typo next to a valid entry", []string{"3", "3f9a0c5e8b1d7e42"}, fingerprint, false, true},
+	}
+
+	for _, tt := range tests {
+		folder := SyncFolder{Name: "assets", From: tt.from}
+		if got := folder.Accepts(tt.signer, tt.paired); got != tt.want {
+			t.Errorf("%s: Accepts = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
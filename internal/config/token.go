@@ -0,0 +1,45 @@
+package config
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TokenHeader carries the control API token
+const TokenHeader = "X-LocalSend-Token"
+
+// TokenPath returns the location of the local API token
+func TokenPath() string {
+	return filepath.Join(Dir(), "token")
+}
+
+// LoadToken returns the per-install API token, creating it on first use
+func LoadToken() (string, error) {
+	data, err := os.ReadFile(TokenPath())
+	if err == nil {
+		if token := strings.TrimSpace(string(data)); token != "" {
+			return token, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read API token: %v", err)
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate API token: %v", err)
+	}
+	token := hex.EncodeToString(b)
+
+	if err := os.MkdirAll(Dir(), 0700); err != nil {
+		return "", fmt.Errorf("failed to create config directory: %v", err)
+	}
+	if err := os.WriteFile(TokenPath(), []byte(token+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("failed to write API token: %v", err)
+	}
+
+	return token, nil
+}
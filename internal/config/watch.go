@@ -0,0 +1,54 @@
+package config
+
+import "path/filepath"
+
+// What happens to a watched file once every target received it
+const (
+	AfterSendMove   = "move"
+	AfterSendDelete = "delete"
+)
+
+// WatchFolder is a folder whose files are sent automatically to a peer or
+// group as soon as they are complete
+type WatchFolder struct {
+	Path string `json:"path"`
+	// Target is a device address (host or host:port) or a group ("@qa-rigs")
+	Target string `json:"target"`
+	// Include and Exclude are glob patterns matched against file names, e.g.
+	// "*.zip"; files must match an Include pattern if any are given
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+	// AfterSend is "move" (the default) or "delete". MoveTo defaults to the
+	// "sent" folder inside Path; relative paths are inside Path too.
+	AfterSend string `json:"afterSend"`
+	MoveTo    string `json:"moveTo"`
+}
+
+// Matches reports whether the folder's patterns select the file name
+func (w WatchFolder) Matches(name string) bool {
+	for _, pattern := range w.Exclude {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return false
+		}
+	}
+	if len(w.Include) == 0 {
+		return true
+	}
+	for _, pattern := range w.Include {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// SentDir returns where sent files are moved to
+func (w WatchFolder) SentDir() string {
+	if w.MoveTo == "" {
+		return filepath.Join(w.Path, "sent")
+	}
+	if filepath.IsAbs(w.MoveTo) {
+		return w.MoveTo
+	}
+	return filepath.Join(w.Path, w.MoveTo)
+}
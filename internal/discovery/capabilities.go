@@ -0,0 +1,108 @@
+package discovery
+
+import (
+	"runtime"
+
+	"localsend/internal/version"
+)
+
+// Capabilities a device may announce
+const (
+	CapCompression = "compression"
+	CapText        = "text"
+	CapParallel    = "parallel"
+	CapRaw         = "raw"
+	CapSync        = "sync"
+)
+
+// Device types
+const (
+	DeviceDesktop = "desktop"
+	DeviceServer  = "server"
+	DeviceMobile  = "mobile"
+)
+
+// HasCapability reports whether caps contains capability
+func HasCapability(caps []string, capability string) bool {
+	for _, c := range caps {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// SetCapabilities sets the capabilities this device announces
+func (s *Service) SetCapabilities(caps []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.capabilities = caps
+}
+
+// describe fills in the version and capability fields of an outgoing message
+func (s *Service) describe(msg *Message) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	msg.Version = version.Protocol
+	msg.DeviceType = s.deviceType
+	msg.OS = runtime.GOOS
+	msg.AppVersion = version.App
+	msg.Capabilities = append([]string{}, s.capabilities...)
+}
+
+// applyInfo copies what a peer told about itself over HTTP into device
+func (d *Device) applyInfo(info *Info) {
+	d.Name = info.Name
+	d.Version = info.Version
+	d.DeviceType = info.DeviceType
+	d.OS = info.OS
+	d.AppVersion = info.AppVersion
+	d.Capabilities = info.Capabilities
+}
+
+// info describes the device the way /api/info would
+func (d *Device) info() *Info {
+	return &Info{
+		Name:         d.Name,
+		Port:         d.Port,
+		Version:      d.Version,
+		DeviceType:   d.DeviceType,
+		OS:           d.OS,
+		AppVersion:   d.AppVersion,
+		Capabilities: d.Capabilities,
+	}
+}
+
+// PeerInfo returns what is known about the peer at host and port, asking it
+// over HTTP if discovery didn't tell. Peers that can't be asked are assumed
+// to speak protocol 1 without any capabilities.
+func (s *Service) PeerInfo(host string, port int) *Info {
+	s.mutex.RLock()
+	var known *Device
+	for _, device := range s.peers {
+		if device.Host() == host && device.Port == port {
+			known = device
+			break
+		}
+	}
+	if known != nil && known.Version >= 2 {
+		info := known.info()
+		s.mutex.RUnlock()
+		return info
+	}
+	s.mutex.RUnlock()
+
+	info, err := FetchInfo(host, port)
+	if err != nil || info.Version == 0 {
+		return &Info{Port: port, Version: 1}
+	}
+
+	if known != nil {
+		s.mutex.Lock()
+		known.applyInfo(info)
+		s.mutex.Unlock()
+	}
+	return info
+}
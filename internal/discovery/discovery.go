@@ -1,57 +1,160 @@
 package discovery
 
 import (
+	"crypto/ed25519"
 	"encoding/json"
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"localsend/internal/config"
+	"localsend/internal/policy"
+	"localsend/internal/ratelimit"
 )
 
 // Device represents a discovered device
 type Device struct {
 	Name string `json:"name"`
 	IP   string `json:"ip"`
+	Zone string `json:"zone,omitempty"` // IPv6 zone (interface) for link-local addresses
 	Port int    `json:"port"`
+
+	// Pinned devices were added manually and are kept across rediscovery
+	Pinned   bool      `json:"pinned"`
+	Address  string    `json:"address,omitempty"` // address as entered by the user
+	LastSeen time.Time `json:"lastSeen"`
+
+	// Protocol details announced by the device; zero for protocol 1 peers
+	Version      int      `json:"version"`
+	DeviceType   string   `json:"deviceType,omitempty"`
+	OS           string   `json:"os,omitempty"`
+	AppVersion   string   `json:"appVersion,omitempty"`
+	Capabilities []string `json:"capabilities"`
+
+	// Fingerprint of the key the device signed its announcement with, and
+	// the short device ID derived from it
+	Fingerprint string `json:"fingerprint,omitempty"`
+	ID          string `json:"id,omitempty"`
+	// Verified is set when the announcement was signed by a paired key
+	Verified bool `json:"verified"`
+	// NameConflict warns that an unverified device uses the name of another
+	NameConflict bool `json:"nameConflict"`
+
+	signed bool // fresh valid signature from the announced address
+}
+
+// Signed reports whether the device's last announcement was freshly signed
+// from the address it was received from
+func (d *Device) Signed() bool {
+	return d.signed
+}
+
+// Host returns the device address including its IPv6 zone, if any
+func (d *Device) Host() string {
+	if d.Zone != "" {
+		return d.IP + "%" + d.Zone
+	}
+	return d.IP
 }
 
 // Message represents UDP discovery message
 type Message struct {
-	Type       string `json:"type"`       // "discover" or "response"
+	Type       string `json:"type"` // "discover" or "response"
 	DeviceName string `json:"deviceName"`
 	IP         string `json:"ip"`
 	Port       int    `json:"port"`
+
+	// Added in protocol 2; older instances ignore them
+	Version      int      `json:"version,omitempty"`
+	DeviceType   string   `json:"deviceType,omitempty"`
+	OS           string   `json:"os,omitempty"`
+	AppVersion   string   `json:"appVersion,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+
+	// Signature over the fields above, made with the sender's identity key
+	PublicKey string `json:"publicKey,omitempty"`
+	Timestamp int64  `json:"timestamp,omitempty"`
+	Signature string `json:"signature,omitempty"`
 }
 
 // Service handles device discovery
 type Service struct {
-	udpPort    int
-	deviceName string
-	conn       *net.UDPConn
-	peers      map[string]*Device
-	mutex      sync.RWMutex
-	stopChan   chan bool
-	running    bool
+	udpPort           int
+	httpPort          int
+	deviceName        string
+	deviceType        string
+	capabilities      []string
+	identity          ed25519.PrivateKey
+	trusted           map[string]string // fingerprint -> name of paired devices
+	responses         *ratelimit.Limiter
+	bans              *ratelimit.BanList
+	policy            *policy.Policy
+	metrics           Metrics
+	includeInterfaces []string
+	excludeInterfaces []string
+	manualPeers       []string
+	sweepEnabled      bool
+	sweepCIDRs        []string
+	sweepMode         string
+	sweepRate         int
+	sweepConcurrency  int
+	conn              *net.UDPConn // IPv4 broadcast socket
+	conns6            []*multicastConn
+	peers             map[string]*Device
+	nonces            map[string]time.Time // request nonces seen within maxClockSkew
+	nonceMutex        sync.Mutex
+	mutex             sync.RWMutex
+	stopChan          chan bool
+	running           bool
 }
 
 // NewService creates a new discovery service
-func NewService(udpPort int, deviceName string) *Service {
-	return &Service{
-		udpPort:    udpPort,
-		deviceName: deviceName,
-		peers:      make(map[string]*Device),
-		stopChan:   make(chan bool),
+func NewService(cfg *config.Config) *Service {
+	sweepRate := cfg.SweepRate
+	if sweepRate <= 0 {
+		sweepRate = 200
+	}
+	sweepConcurrency := cfg.SweepConcurrency
+	if sweepConcurrency <= 0 {
+		sweepConcurrency = 32
+	}
+
+	s := &Service{
+		udpPort:           cfg.UDPPort,
+		httpPort:          cfg.HTTPPort,
+		deviceName:        cfg.DeviceName,
+		deviceType:        cfg.DeviceType,
+		identity:          cfg.Identity,
+		responses:         ratelimit.NewLimiter(cfg.DiscoveryRate, discoveryBurst),
+		policy:            policy.New(cfg.Allow, cfg.Block),
+		bans:              ratelimit.NewBanList(cfg.BanThreshold, time.Minute, time.Duration(cfg.BanMinutes)*time.Minute),
+		includeInterfaces: cfg.Interfaces,
+		excludeInterfaces: cfg.ExcludeInterfaces,
+		manualPeers:       cfg.Peers,
+		sweepEnabled:      cfg.Sweep,
+		sweepCIDRs:        cfg.SweepCIDRs,
+		sweepMode:         cfg.SweepMode,
+		sweepRate:         sweepRate,
+		sweepConcurrency:  sweepConcurrency,
+		peers:             make(map[string]*Device),
+		nonces:            make(map[string]time.Time),
+		stopChan:          make(chan bool),
 	}
+	s.SetTrustedPeers(cfg.TrustedPeers)
+
+	return s
 }
 
 // Start begins the discovery service
 func (s *Service) Start() error {
-	addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf(":%d", s.udpPort))
+	addr, err := net.ResolveUDPAddr("udp4", fmt.Sprintf(":%d", s.udpPort))
 	if err != nil {
 		return fmt.Errorf("failed to resolve UDP address: %v", err)
 	}
 
-	s.conn, err = net.ListenUDP("udp", addr)
+	s.conn, err = net.ListenUDP("udp4", addr)
 	if err != nil {
 		return fmt.Errorf("failed to listen on UDP: %v", err)
 	}
@@ -60,7 +163,19 @@ func (s *Service) Start() error {
 	fmt.Printf("Discovery service started on UDP port %d\n", s.udpPort)
 
 	// Start listening for messages
-	go s.listen()
+	go s.listen(s.conn)
+
+	// Join the IPv6 multicast group
+	s.startIPv6()
+
+	// Add manually configured peers
+	go func() {
+		for _, address := range s.manualPeers {
+			if _, err := s.AddPinnedPeer(address); err != nil {
+				fmt.Printf("Error adding peer %s: %v\n", address, err)
+			}
+		}
+	}()
 
 	// Start periodic cleanup of old peers
 	go s.cleanupPeers()
@@ -80,17 +195,20 @@ func (s *Service) Stop() {
 	if s.conn != nil {
 		s.conn.Close()
 	}
+	for _, mc := range s.conns6 {
+		mc.conn.Close()
+	}
 
 	fmt.Println("Discovery service stopped")
 }
 
-// listen handles incoming UDP messages
-func (s *Service) listen() {
-	buffer := make([]byte, 1024)
+// listen handles incoming UDP messages on conn
+func (s *Service) listen(conn *net.UDPConn) {
+	buffer := make([]byte, 8192)
 
 	for s.running {
-		s.conn.SetReadDeadline(time.Now().Add(1 * time.Second))
-		n, addr, err := s.conn.ReadFromUDP(buffer)
+		conn.SetReadDeadline(time.Now().Add(1 * time.Second))
+		n, addr, err := conn.ReadFromUDP(buffer)
 		if err != nil {
 			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 				continue
@@ -101,37 +219,80 @@ func (s *Service) listen() {
 			continue
 		}
 
+		if s.excluded(addr.IP) {
+			continue
+		}
+		if s.bans.Banned(addr.IP.String()) {
+			atomic.AddInt64(&s.metrics.Banned, 1)
+			continue
+		}
+		atomic.AddInt64(&s.metrics.Received, 1)
+
 		var msg Message
 		if err := json.Unmarshal(buffer[:n], &msg); err != nil {
-			fmt.Printf("Error unmarshaling message: %v\n", err)
+			s.malformed(addr.IP.String(), "malformed discovery packet")
 			continue
 		}
 
-		s.handleMessage(&msg, addr)
+		s.handleMessage(&msg, addr, conn)
 	}
 }
 
 // handleMessage processes incoming discovery messages
-func (s *Service) handleMessage(msg *Message, addr *net.UDPAddr) {
+func (s *Service) handleMessage(msg *Message, addr *net.UDPAddr, conn *net.UDPConn) {
+	fingerprint, signed, err := verify(msg, addr)
+	if err != nil {
+		fmt.Printf("Ignoring discovery message from %s: %v\n", addr.IP, err)
+		s.malformed(addr.IP.String(), "invalid discovery signature")
+		return
+	}
+	if fingerprint == s.Fingerprint() {
+		// Our own broadcast or multicast coming back
+		return
+	}
+	// A stale, replayed or relayed announcement proves nothing about its
+	// sender, so it doesn't get the key's fingerprint or device ID
+	if !signed {
+		fingerprint = ""
+	}
+
+	// Blocked devices neither see us nor show up as peers
+	peer := policy.Peer{Fingerprint: fingerprint, IP: addr.IP.String(), Name: msg.DeviceName}
+	if !s.policy.Check(peer).Allowed {
+		return
+	}
+
 	switch msg.Type {
 	case "discover":
-		// Someone is looking for devices, respond with our info
-		s.sendResponse(addr)
+		// Someone is looking for devices, respond with our info unless they
+		// ask so often that we would be flooding them, or a spoofed victim
+		if !s.responses.Allow(addr.IP.String()) {
+			atomic.AddInt64(&s.metrics.RateLimited, 1)
+			return
+		}
+		s.sendResponse(addr, conn)
 	case "response":
 		// Someone responded to our discovery, add them to peers
-		s.addPeer(msg, addr)
+		s.addPeer(msg, addr, fingerprint, signed)
 	}
 }
 
-// sendResponse sends a response to a discovery request
-func (s *Service) sendResponse(addr *net.UDPAddr) {
-	localIP := s.getLocalIP()
+// sendResponse sends a response to a discovery request, announcing the
+// address of the interface the request arrived on
+func (s *Service) sendResponse(addr *net.UDPAddr, conn *net.UDPConn) {
+	localIP := s.localIPFor(addr.IP)
+	if addr.IP.To4() == nil {
+		localIP = localIPv6For(addr)
+	}
+
 	response := Message{
 		Type:       "response",
 		DeviceName: s.deviceName,
 		IP:         localIP,
-		Port:       8080, // HTTP server port
+		Port:       s.httpPort,
 	}
+	s.describe(&response)
+	s.sign(&response)
 
 	data, err := json.Marshal(response)
 	if err != nil {
@@ -139,76 +300,146 @@ func (s *Service) sendResponse(addr *net.UDPAddr) {
 		return
 	}
 
-	_, err = s.conn.WriteToUDP(data, addr)
+	_, err = conn.WriteToUDP(data, addr)
 	if err != nil {
 		fmt.Printf("Error sending response: %v\n", err)
+		return
 	}
+	atomic.AddInt64(&s.metrics.Responses, 1)
 }
 
-// addPeer adds a discovered peer to the list
-func (s *Service) addPeer(msg *Message, addr *net.UDPAddr) {
+// addPeer adds a discovered peer to the list at the address the packet came
+// from, never at the one named in the message. fingerprint is empty unless
+// the announcement was freshly signed from that address.
+func (s *Service) addPeer(msg *Message, addr *net.UDPAddr, fingerprint string, signed bool) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	_, trusted := s.trusted[fingerprint]
+
 	device := &Device{
-		Name: msg.DeviceName,
-		IP:   msg.IP,
-		Port: msg.Port,
+		Name:         msg.DeviceName,
+		IP:           addr.IP.String(),
+		Port:         msg.Port,
+		LastSeen:     time.Now(),
+		Version:      msg.Version,
+		DeviceType:   msg.DeviceType,
+		OS:           msg.OS,
+		AppVersion:   msg.AppVersion,
+		Capabilities: msg.Capabilities,
+		Fingerprint:  fingerprint,
+		ID:           DeviceID(fingerprint),
+		Verified:     signed && trusted,
+		signed:       signed,
+	}
+
+	// Link-local IPv6 addresses are only usable together with the zone the
+	// packet arrived on
+	if addr.IP.To4() == nil {
+		device.Zone = addr.Zone
+	}
+
+	// Keep manually added peers pinned when they answer, at the address the
+	// user entered since what they announce may not be routable from here
+	if existing, ok := s.peers[hostKey(addr)]; ok && existing.Pinned {
+		device.IP = existing.IP
+		device.Zone = existing.Zone
+		device.Pinned = true
+		device.Address = existing.Address
 	}
 
-	s.peers[addr.IP.String()] = device
-	fmt.Printf("Discovered device: %s (%s:%d)\n", device.Name, device.IP, device.Port)
+	s.peers[hostKey(addr)] = device
+	fmt.Printf("Discovered device: %s (%s)\n", device.Name, net.JoinHostPort(device.Host(), fmt.Sprint(device.Port)))
 }
 
-// DiscoverDevices broadcasts a discovery message
-func (s *Service) DiscoverDevices() ([]*Device, error) {
+// DiscoverDevices broadcasts a discovery message. With sweep, or when sweeping
+// is enabled in the configuration, every local address is probed as well.
+func (s *Service) DiscoverDevices(sweep bool) ([]*Device, error) {
 	if !s.running {
 		return nil, fmt.Errorf("discovery service not running")
 	}
 
-	// Clear existing peers
+	// Clear existing peers, keeping the manually added ones
 	s.mutex.Lock()
-	s.peers = make(map[string]*Device)
+	for key, device := range s.peers {
+		if !device.Pinned {
+			delete(s.peers, key)
+		}
+	}
 	s.mutex.Unlock()
 
-	// Broadcast discovery message
-	localIP := s.getLocalIP()
-	msg := Message{
-		Type:       "discover",
-		DeviceName: s.deviceName,
-		IP:         localIP,
-		Port:       8080,
+	// Probe manually added peers, which broadcast may not reach
+	for _, device := range s.pinnedPeers() {
+		go s.probePeer(device)
 	}
 
-	data, err := json.Marshal(msg)
-	if err != nil {
-		return nil, fmt.Errorf("error marshaling discovery message: %v", err)
+	// Broadcast discovery message on every interface
+	sent := 0
+	var lastErr error
+	for _, li := range s.interfaces() {
+		if li.Broadcast == nil {
+			continue
+		}
+		broadcast := &net.UDPAddr{IP: li.Broadcast, Port: s.udpPort}
+		if err := s.sendDiscover(s.conn, li.IP.String(), broadcast); err != nil {
+			fmt.Printf("Error broadcasting on %s: %v\n", li.Name, err)
+			lastErr = err
+			continue
+		}
+		sent++
 	}
 
-	// Broadcast to subnet
-	broadcastAddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("255.255.255.255:%d", s.udpPort))
-	if err != nil {
-		return nil, fmt.Errorf("error resolving broadcast address: %v", err)
+	// Multicast to IPv6 neighbours
+	sent += s.sendMulticastDiscover()
+
+	// Fall back to the limited broadcast address if no interface could be used
+	if sent == 0 {
+		broadcast := &net.UDPAddr{IP: net.IPv4bcast, Port: s.udpPort}
+		if err := s.sendDiscover(s.conn, s.getLocalIP(), broadcast); err != nil {
+			if lastErr == nil {
+				lastErr = err
+			}
+			return nil, fmt.Errorf("error broadcasting discovery message: %v", lastErr)
+		}
 	}
 
-	_, err = s.conn.WriteToUDP(data, broadcastAddr)
-	if err != nil {
-		return nil, fmt.Errorf("error broadcasting discovery message: %v", err)
+	// Probe every address when broadcast and multicast may be filtered
+	sweepDone := make(chan struct{})
+	if sweep || s.sweepEnabled {
+		go func() {
+			s.Sweep()
+			close(sweepDone)
+		}()
+	} else {
+		close(sweepDone)
 	}
 
 	// Wait for responses
 	time.Sleep(3 * time.Second)
+	<-sweepDone
 
 	// Return discovered devices
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+	return s.GetPeers(), nil
+}
 
-	devices := make([]*Device, 0, len(s.peers))
-	for _, device := range s.peers {
-		devices = append(devices, device)
+// sendDiscover sends a discovery message announcing localIP to a broadcast or multicast address
+func (s *Service) sendDiscover(conn *net.UDPConn, localIP string, dest *net.UDPAddr) error {
+	msg := Message{
+		Type:       "discover",
+		DeviceName: s.deviceName,
+		IP:         localIP,
+		Port:       s.httpPort,
 	}
+	s.describe(&msg)
+	s.sign(&msg)
 
-	return devices, nil
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("error marshaling discovery message: %v", err)
+	}
+
+	_, err = conn.WriteToUDP(data, dest)
+	return err
 }
 
 // GetPeers returns the current list of discovered peers
@@ -218,12 +449,27 @@ func (s *Service) GetPeers() []*Device {
 
 	devices := make([]*Device, 0, len(s.peers))
 	for _, device := range s.peers {
-		devices = append(devices, device)
+		if !s.policy.Check(device.policyPeer()).Allowed {
+			continue
+		}
+		snapshot := *device
+		devices = append(devices, &snapshot)
 	}
+	s.markConflicts(devices)
 
 	return devices
 }
 
+// DeviceName returns the name this device announces to peers
+func (s *Service) DeviceName() string {
+	return s.deviceName
+}
+
+// LocalIP returns the address other devices on the LAN can reach us at
+func (s *Service) LocalIP() string {
+	return s.getLocalIP()
+}
+
 // cleanupPeers removes old peers periodically
 func (s *Service) cleanupPeers() {
 	ticker := time.NewTicker(30 * time.Second)
@@ -233,21 +479,26 @@ func (s *Service) cleanupPeers() {
 		select {
 		case <-ticker.C:
 			// For now, we keep all peers. In a real implementation,
-			// you might want to ping peers and remove unresponsive ones
+			// you might want to ping peers and remove unresponsive ones.
+			// Manually added peers are probed to refresh their details.
+			for _, device := range s.pinnedPeers() {
+				go s.probePeer(device)
+			}
 		case <-s.stopChan:
 			return
 		}
 	}
 }
 
-// getLocalIP returns the local IP address
-func (s *Service) getLocalIP() string {
-	conn, err := net.Dial("udp", "8.8.8.8:80")
-	if err != nil {
-		return "127.0.0.1"
+// hostKey identifies a peer by the source address of its packets
+func hostKey(addr *net.UDPAddr) string {
+	if addr.Zone != "" {
+		return addr.IP.String() + "%" + addr.Zone
 	}
-	defer conn.Close()
+	return addr.IP.String()
+}
 
-	localAddr := conn.LocalAddr().(*net.UDPAddr)
-	return localAddr.IP.String()
-}
\ No newline at end of file
+// getLocalIP returns the address of the first usable interface
+func (s *Service) getLocalIP() string {
+	return s.localIPFor(nil)
+}
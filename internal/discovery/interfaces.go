@@ -0,0 +1,139 @@
+package discovery
+
+import (
+	"net"
+	"strings"
+)
+
+// localInterface is an IPv4 address of a usable network interface
+type localInterface struct {
+	Name      string
+	IP        net.IP
+	Network   *net.IPNet
+	Broadcast net.IP // nil if the interface can't broadcast
+}
+
+// interfaces returns the IPv4 interfaces discovery should use, honouring
+// the include and exclude lists from the configuration
+func (s *Service) interfaces() []localInterface {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+
+	var result []localInterface
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.To4() == nil {
+				continue
+			}
+
+			li := localInterface{
+				Name:    iface.Name,
+				IP:      ipNet.IP.To4(),
+				Network: &net.IPNet{IP: ipNet.IP.To4().Mask(ipNet.Mask), Mask: ipNet.Mask},
+			}
+			if iface.Flags&net.FlagBroadcast != 0 {
+				li.Broadcast = directedBroadcast(li.IP, ipNet.Mask)
+			}
+
+			if !s.interfaceAllowed(li) {
+				continue
+			}
+			result = append(result, li)
+		}
+	}
+
+	return result
+}
+
+// interfaceAllowed applies the include and exclude lists to an interface
+func (s *Service) interfaceAllowed(li localInterface) bool {
+	if len(s.includeInterfaces) > 0 && !matchInterface(li, s.includeInterfaces) {
+		return false
+	}
+	return !matchInterface(li, s.excludeInterfaces)
+}
+
+// excluded reports whether ip belongs to the network of an excluded interface
+func (s *Service) excluded(ip net.IP) bool {
+	if len(s.excludeInterfaces) == 0 {
+		return false
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return false
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || !ipNet.Contains(ip) {
+				continue
+			}
+			li := localInterface{Name: iface.Name, IP: ipNet.IP, Network: ipNet}
+			if matchInterface(li, s.excludeInterfaces) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchInterface reports whether an interface matches any name or CIDR in patterns
+func matchInterface(li localInterface, patterns []string) bool {
+	for _, pattern := range patterns {
+		if strings.Contains(pattern, "/") {
+			_, cidr, err := net.ParseCIDR(pattern)
+			if err == nil && cidr.Contains(li.IP) {
+				return true
+			}
+			continue
+		}
+		if pattern == li.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// directedBroadcast returns the broadcast address of the network ip belongs to
+func directedBroadcast(ip net.IP, mask net.IPMask) net.IP {
+	ip4 := ip.To4()
+	if len(mask) == net.IPv6len {
+		mask = mask[12:]
+	}
+	broadcast := make(net.IP, net.IPv4len)
+	for i := range ip4 {
+		broadcast[i] = ip4[i] | ^mask[i]
+	}
+	return broadcast
+}
+
+// localIPFor returns our address on the interface that can reach remote
+func (s *Service) localIPFor(remote net.IP) string {
+	ifaces := s.interfaces()
+	for _, li := range ifaces {
+		if li.Network.Contains(remote) {
+			return li.IP.String()
+		}
+	}
+	if len(ifaces) > 0 {
+		return ifaces[0].IP.String()
+	}
+	return "127.0.0.1"
+}
@@ -0,0 +1,134 @@
+package discovery
+
+import (
+	"fmt"
+	"net"
+)
+
+// multicastGroup is the link-local IPv6 multicast group used for discovery
+var multicastGroup = net.ParseIP("ff02::4c53")
+
+// multicastConn is an IPv6 discovery socket joined to the group on one interface
+type multicastConn struct {
+	iface net.Interface
+	conn  *net.UDPConn
+}
+
+// startIPv6 joins the discovery multicast group on every usable IPv6 interface.
+// IPv6 is optional, so failures are only logged.
+func (s *Service) startIPv6() {
+	for _, iface := range s.multicastInterfaces() {
+		iface := iface
+		conn, err := net.ListenMulticastUDP("udp6", &iface, &net.UDPAddr{IP: multicastGroup, Port: s.udpPort})
+		if err != nil {
+			fmt.Printf("Error joining IPv6 multicast group on %s: %v\n", iface.Name, err)
+			continue
+		}
+
+		s.conns6 = append(s.conns6, &multicastConn{iface: iface, conn: conn})
+		fmt.Printf("Discovery listening on [%s%%%s]:%d\n", multicastGroup, iface.Name, s.udpPort)
+		go s.listen(conn)
+	}
+}
+
+// multicastInterfaces returns the interfaces with IPv6 addresses that discovery should use
+func (s *Service) multicastInterfaces() []net.Interface {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+
+	var result []net.Interface
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+
+		ip := interfaceIPv6(iface, nil)
+		if ip == nil {
+			continue
+		}
+
+		li := localInterface{Name: iface.Name, IP: ip}
+		if s.interfaceAllowed(li) {
+			result = append(result, iface)
+		}
+	}
+
+	return result
+}
+
+// interfaceIPv6 returns an IPv6 address of iface, preferring one on the same
+// network as remote, then a link-local address
+func interfaceIPv6(iface net.Interface, remote net.IP) net.IP {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil
+	}
+
+	var linkLocal, other net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.To4() != nil {
+			continue
+		}
+		if remote != nil && ipNet.Contains(remote) && !(remote.IsLinkLocalUnicast() && !ipNet.IP.IsLinkLocalUnicast()) {
+			return ipNet.IP
+		}
+		if ipNet.IP.IsLinkLocalUnicast() {
+			if linkLocal == nil {
+				linkLocal = ipNet.IP
+			}
+		} else if other == nil {
+			other = ipNet.IP
+		}
+	}
+
+	if remote != nil && remote.IsLinkLocalUnicast() && linkLocal != nil {
+		return linkLocal
+	}
+	if other != nil {
+		return other
+	}
+	return linkLocal
+}
+
+// localIPv6For returns our address on the interface a request from remote arrived on
+func localIPv6For(remote *net.UDPAddr) string {
+	if remote.Zone != "" {
+		if iface, err := net.InterfaceByName(remote.Zone); err == nil {
+			if ip := interfaceIPv6(*iface, remote.IP); ip != nil {
+				return ip.String()
+			}
+		}
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return ""
+	}
+	for _, iface := range ifaces {
+		if ip := interfaceIPv6(iface, remote.IP); ip != nil && !ip.IsLoopback() {
+			return ip.String()
+		}
+	}
+	return ""
+}
+
+// sendMulticastDiscover sends a discovery message to the IPv6 group on every joined interface
+func (s *Service) sendMulticastDiscover() int {
+	sent := 0
+	for _, mc := range s.conns6 {
+		ip := interfaceIPv6(mc.iface, nil)
+		if ip == nil {
+			continue
+		}
+		group := &net.UDPAddr{IP: multicastGroup, Port: s.udpPort, Zone: mc.iface.Name}
+		if err := s.sendDiscover(mc.conn, ip.String(), group); err != nil {
+			fmt.Printf("Error sending IPv6 discovery on %s: %v\n", mc.iface.Name, err)
+			continue
+		}
+		sent++
+	}
+	return sent
+}
@@ -0,0 +1,80 @@
+package discovery
+
+import (
+	"strings"
+	"sync/atomic"
+
+	"localsend/internal/policy"
+	"localsend/internal/ratelimit"
+)
+
+// discoveryBurst is how many discovery responses a source may get at once,
+// enough for a peer broadcasting on several interfaces
+const discoveryBurst = 10
+
+// Metrics counts discovery traffic
+type Metrics struct {
+	Received    int64 `json:"received"`
+	Responses   int64 `json:"responses"`
+	RateLimited int64 `json:"rateLimited"`
+	Malformed   int64 `json:"malformed"`
+	Banned      int64 `json:"banned"`
+}
+
+// Metrics returns a snapshot of the discovery counters
+func (s *Service) Metrics() Metrics {
+	return Metrics{
+		Received:    atomic.LoadInt64(&s.metrics.Received),
+		Responses:   atomic.LoadInt64(&s.metrics.Responses),
+		RateLimited: atomic.LoadInt64(&s.metrics.RateLimited),
+		Malformed:   atomic.LoadInt64(&s.metrics.Malformed),
+		Banned:      atomic.LoadInt64(&s.metrics.Banned),
+	}
+}
+
+// Bans returns the ban list of the discovery listener. Its strikes come
+// from UDP packets whose source can be spoofed, so it is kept apart from
+// the HTTP listener's.
+func (s *Service) Bans() *ratelimit.BanList {
+	return s.bans
+}
+
+// Policy returns the allow and block rules applied to peers
+func (s *Service) Policy() *policy.Policy {
+	return s.policy
+}
+
+// CheckUnsigned applies the policy to a peer that didn't sign its request.
+// It is also refused when a signed device seen at its address or under its
+// name is blocked, so that blocking a device's key can't be evaded by not
+// signing.
+func (s *Service) CheckUnsigned(peer policy.Peer) policy.Decision {
+	decision := s.policy.Check(peer)
+	if !decision.Allowed {
+		return decision
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, device := range s.peers {
+		if !device.signed || (device.IP != peer.IP && (peer.Name == "" || !strings.EqualFold(device.Name, peer.Name))) {
+			continue
+		}
+		if known := s.policy.Check(device.policyPeer()); known.Reason == policy.ReasonBlocked {
+			return known
+		}
+	}
+	return decision
+}
+
+// policyPeer describes the device for policy checks
+func (d *Device) policyPeer() policy.Peer {
+	return policy.Peer{Fingerprint: d.Fingerprint, IP: d.IP, Name: d.Name}
+}
+
+// malformed records a packet that could not be used and strikes its source
+func (s *Service) malformed(source, reason string) {
+	atomic.AddInt64(&s.metrics.Malformed, 1)
+	s.bans.Strike(source, reason)
+}
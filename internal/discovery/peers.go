@@ -0,0 +1,220 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// probeTimeout bounds how long probing a manually added peer may take
+const probeTimeout = 3 * time.Second
+
+// Info describes this device to peers asking over HTTP (GET /api/info)
+type Info struct {
+	Name         string   `json:"name"`
+	Port         int      `json:"port"`
+	Version      int      `json:"version"`
+	DeviceType   string   `json:"deviceType,omitempty"`
+	OS           string   `json:"os,omitempty"`
+	AppVersion   string   `json:"appVersion,omitempty"`
+	Capabilities []string `json:"capabilities"`
+}
+
+// Info returns the description of this device served at /api/info
+func (s *Service) Info() *Info {
+	var msg Message
+	s.describe(&msg)
+
+	return &Info{
+		Name:         s.deviceName,
+		Port:         s.httpPort,
+		Version:      msg.Version,
+		DeviceType:   msg.DeviceType,
+		OS:           msg.OS,
+		AppVersion:   msg.AppVersion,
+		Capabilities: msg.Capabilities,
+	}
+}
+
+// ParsePeerAddress splits a manually entered peer address into host and HTTP
+// port. Accepted forms are host, host:port, ipv6 and [ipv6%zone]:port.
+func ParsePeerAddress(address string, defaultPort int) (string, int, error) {
+	address = strings.TrimSpace(address)
+	if address == "" {
+		return "", 0, fmt.Errorf("empty peer address")
+	}
+
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		// No port given
+		host = strings.Trim(address, "[]")
+		if strings.Count(host, ":") == 1 {
+			return "", 0, fmt.Errorf("invalid peer address %q", address)
+		}
+		return host, defaultPort, nil
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid port in peer address %q", address)
+	}
+	return host, port, nil
+}
+
+// resolveHost turns a host name or address literal into an IP and zone
+func resolveHost(host string) (net.IP, string, error) {
+	addr, zone, _ := strings.Cut(host, "%")
+	if ip := net.ParseIP(addr); ip != nil {
+		return ip, zone, nil
+	}
+
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			return ip, "", nil
+		}
+	}
+	if len(ips) == 0 {
+		return nil, "", fmt.Errorf("no addresses for %s", host)
+	}
+	return ips[0], "", nil
+}
+
+// AddPinnedPeer adds a manually entered peer that survives rediscovery and
+// probes it for its name
+func (s *Service) AddPinnedPeer(address string) (*Device, error) {
+	host, port, err := ParsePeerAddress(address, s.httpPort)
+	if err != nil {
+		return nil, err
+	}
+
+	ip, zone, err := resolveHost(host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %v", host, err)
+	}
+
+	device := &Device{
+		Name:    host,
+		IP:      ip.String(),
+		Zone:    zone,
+		Port:    port,
+		Pinned:  true,
+		Address: address,
+	}
+
+	s.mutex.Lock()
+	s.peers[hostKey(&net.UDPAddr{IP: ip, Zone: zone})] = device
+	s.mutex.Unlock()
+
+	s.probePeer(device)
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	snapshot := *device
+	return &snapshot, nil
+}
+
+// RemovePinnedPeer removes a manually added peer
+func (s *Service) RemovePinnedPeer(address string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for key, device := range s.peers {
+		if device.Pinned && device.Address == address {
+			delete(s.peers, key)
+			return true
+		}
+	}
+	return false
+}
+
+// pinnedPeers returns the manually added peers
+func (s *Service) pinnedPeers() []*Device {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var devices []*Device
+	for _, device := range s.peers {
+		if device.Pinned {
+			devices = append(devices, device)
+		}
+	}
+	return devices
+}
+
+// probePeer asks a peer for its details over HTTP and sends it a unicast
+// discovery message, whose response is handled like any other
+func (s *Service) probePeer(device *Device) {
+	s.mutex.RLock()
+	host, port := device.Host(), device.Port
+	s.mutex.RUnlock()
+
+	if ip, zone, err := resolveHost(host); err == nil {
+		dest := &net.UDPAddr{IP: ip, Port: s.udpPort, Zone: zone}
+		if conn := s.connFor(ip); conn != nil {
+			if err := s.sendDiscover(conn, s.localIPFor(ip), dest); err != nil {
+				fmt.Printf("Error probing %s over UDP: %v\n", host, err)
+			}
+		}
+	}
+
+	info, err := FetchInfo(host, port)
+	if err != nil {
+		fmt.Printf("Error probing %s over HTTP: %v\n", host, err)
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	device.applyInfo(info)
+	device.LastSeen = time.Now()
+}
+
+// connFor returns the socket to use for unicast messages to ip
+func (s *Service) connFor(ip net.IP) *net.UDPConn {
+	if ip.To4() != nil {
+		return s.conn
+	}
+	if len(s.conns6) > 0 {
+		return s.conns6[0].conn
+	}
+	return nil
+}
+
+// FetchInfo retrieves the details a device serves at /api/info
+func FetchInfo(host string, port int) (*Info, error) {
+	return fetchInfo(&http.Client{Timeout: probeTimeout}, host, port)
+}
+
+// fetchInfo retrieves /api/info using client
+func fetchInfo(client *http.Client, host string, port int) (*Info, error) {
+	u := url.URL{
+		Scheme: "http",
+		Host:   net.JoinHostPort(host, strconv.Itoa(port)),
+		Path:   "/api/info",
+	}
+
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("peer returned status: %s", resp.Status)
+	}
+
+	var info Info
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("invalid info response: %v", err)
+	}
+	return &info, nil
+}
@@ -0,0 +1,239 @@
+package discovery
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"localsend/internal/config"
+)
+
+// maxClockSkew is how old or early a signed announcement may be before it is
+// no longer trusted, which limits replaying captured announcements
+const maxClockSkew = 2 * time.Minute
+
+// Fingerprint identifies a device by its public key
+func Fingerprint(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:])
+}
+
+// DeviceID is the short form of a fingerprint shown to users and usable in
+// policy rules
+func DeviceID(fingerprint string) string {
+	if len(fingerprint) < 16 {
+		return fingerprint
+	}
+	return fingerprint[:16]
+}
+
+// Fingerprint returns the fingerprint of this device's key
+func (s *Service) Fingerprint() string {
+	return Fingerprint(s.identity.Public().(ed25519.PublicKey))
+}
+
+// SetTrustedPeers replaces the paired devices whose announcements are verified
+func (s *Service) SetTrustedPeers(peers []config.TrustedPeer) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.trusted = make(map[string]string, len(peers))
+	for _, peer := range peers {
+		s.trusted[strings.ToLower(peer.Fingerprint)] = peer.Name
+	}
+
+	// Re-evaluate the peers already found
+	for _, device := range s.peers {
+		_, trusted := s.trusted[device.Fingerprint]
+		device.Verified = device.signed && trusted
+	}
+}
+
+// IsTrusted reports whether the key fingerprint belongs to a paired device
+func (s *Service) IsTrusted(fingerprint string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	_, trusted := s.trusted[fingerprint]
+	return trusted
+}
+
+// signedPayload is the canonical encoding of the signed fields of a message
+func signedPayload(msg *Message) []byte {
+	data, _ := json.Marshal([]interface{}{
+		"localsend-discovery",
+		msg.Type,
+		msg.DeviceName,
+		msg.IP,
+		msg.Port,
+		msg.Version,
+		msg.DeviceType,
+		msg.OS,
+		msg.AppVersion,
+		msg.Capabilities,
+		msg.PublicKey,
+		msg.Timestamp,
+	})
+	return data
+}
+
+// sign adds this device's public key, a timestamp and a signature to msg
+func (s *Service) sign(msg *Message) {
+	msg.PublicKey = base64.StdEncoding.EncodeToString(s.identity.Public().(ed25519.PublicKey))
+	msg.Timestamp = time.Now().Unix()
+	msg.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(s.identity, signedPayload(msg)))
+}
+
+// verify checks the signature of msg received from addr. It returns the
+// sender's fingerprint, or "" for unsigned messages from older versions, and
+// whether the announcement is fresh and made from the address it names.
+func verify(msg *Message, addr *net.UDPAddr) (string, bool, error) {
+	if msg.Signature == "" {
+		return "", false, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(msg.PublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return "", false, fmt.Errorf("invalid public key")
+	}
+	signature, err := base64.StdEncoding.DecodeString(msg.Signature)
+	if err != nil || !ed25519.Verify(key, signedPayload(msg), signature) {
+		return "", false, fmt.Errorf("invalid signature")
+	}
+
+	age := time.Since(time.Unix(msg.Timestamp, 0))
+	fresh := age < maxClockSkew && age > -maxClockSkew
+	sameAddress := net.ParseIP(msg.IP).Equal(addr.IP)
+
+	return Fingerprint(key), fresh && sameAddress, nil
+}
+
+// Headers carrying the signature of a transfer request
+const (
+	keyHeader       = "X-LocalSend-Key"
+	timestampHeader = "X-LocalSend-Timestamp"
+	nonceHeader     = "X-LocalSend-Nonce"
+	signatureHeader = "X-LocalSend-Signature"
+)
+
+// requestPayload is the canonical encoding of the signed parts of a request
+func requestPayload(r *http.Request, sender, key, timestamp, nonce string) []byte {
+	data, _ := json.Marshal([]interface{}{
+		"localsend-request",
+		r.Method,
+		r.URL.Path,
+		r.URL.RawQuery,
+		sender,
+		key,
+		timestamp,
+		nonce,
+	})
+	return data
+}
+
+// SignRequest signs a transfer request to a peer made on behalf of sender,
+// so that the receiver can apply its policy to our fingerprint
+func (s *Service) SignRequest(r *http.Request, sender string) {
+	key := base64.StdEncoding.EncodeToString(s.identity.Public().(ed25519.PublicKey))
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	b := make([]byte, 16)
+	rand.Read(b)
+	nonce := hex.EncodeToString(b)
+	signature := ed25519.Sign(s.identity, requestPayload(r, sender, key, timestamp, nonce))
+
+	r.Header.Set(keyHeader, key)
+	r.Header.Set(timestampHeader, timestamp)
+	r.Header.Set(nonceHeader, nonce)
+	r.Header.Set(signatureHeader, base64.StdEncoding.EncodeToString(signature))
+}
+
+// VerifyRequest returns the fingerprint of the peer that signed r on behalf
+// of sender, or "" if the request is not signed. The signature doesn't cover
+// the body, so each signed request is accepted only once.
+func (s *Service) VerifyRequest(r *http.Request, sender string) (string, error) {
+	encoded := r.Header.Get(keyHeader)
+	if encoded == "" && r.Header.Get(signatureHeader) == "" {
+		return "", nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return "", fmt.Errorf("invalid public key")
+	}
+	signature, err := base64.StdEncoding.DecodeString(r.Header.Get(signatureHeader))
+	if err != nil {
+		return "", fmt.Errorf("invalid signature")
+	}
+
+	timestamp := r.Header.Get(timestampHeader)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid timestamp")
+	}
+	if age := time.Since(time.Unix(unix, 0)); age > maxClockSkew || age < -maxClockSkew {
+		return "", fmt.Errorf("request signature expired")
+	}
+
+	nonce := r.Header.Get(nonceHeader)
+	if len(nonce) < 16 {
+		return "", fmt.Errorf("missing request nonce")
+	}
+	if !ed25519.Verify(key, requestPayload(r, sender, encoded, timestamp, nonce), signature) {
+		return "", fmt.Errorf("invalid signature")
+	}
+	if !s.freshNonce(encoded + "/" + nonce) {
+		return "", fmt.Errorf("request signature already used")
+	}
+	return Fingerprint(key), nil
+}
+
+// freshNonce records a request nonce, reporting false if it was seen before.
+// Nonces are forgotten once their signatures would have expired anyway.
+func (s *Service) freshNonce(nonce string) bool {
+	s.nonceMutex.Lock()
+	defer s.nonceMutex.Unlock()
+
+	now := time.Now()
+	for seen, at := range s.nonces {
+		if now.Sub(at) > 2*maxClockSkew {
+			delete(s.nonces, seen)
+		}
+	}
+	if _, ok := s.nonces[nonce]; ok {
+		return false
+	}
+	s.nonces[nonce] = now
+	return true
+}
+
+// markConflicts flags unverified devices using the name of another device
+// with a different key, or of a paired device. Called with the lock held.
+func (s *Service) markConflicts(devices []*Device) {
+	for _, device := range devices {
+		device.NameConflict = false
+		if device.Verified {
+			continue
+		}
+
+		for fingerprint, name := range s.trusted {
+			if strings.EqualFold(name, device.Name) && fingerprint != device.Fingerprint {
+				device.NameConflict = true
+			}
+		}
+		for _, other := range devices {
+			sameKey := device.Fingerprint != "" && other.Fingerprint == device.Fingerprint
+			if other != device && strings.EqualFold(other.Name, device.Name) && !sameKey {
+				device.NameConflict = true
+			}
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package discovery
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"testing"
+
+	"localsend/internal/config"
+)
+
+func newTestService(t *testing.T, name string) *Service {
+	_, identity, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewService(&config.Config{DeviceName: name, HTTPPort: 8080, Identity: identity})
+}
+
+func TestReplayedAnnouncement(t *testing.T) {
+	victim := newTestService(t, "qa-1")
+	receiver := newTestService(t, "receiver")
+
+	msg := Message{Type: "response", DeviceName: "qa-1", IP: "192.168.1.20", Port: 8080}
+	victim.describe(&msg)
+	victim.sign(&msg)
+
+	// From the address it names, the announcement identifies the key
+	receiver.handleMessage(&msg, &net.UDPAddr{IP: net.ParseIP("192.168.1.20")}, nil)
+	// Replayed from another address it must not
+	replay := msg
+	receiver.handleMessage(&replay, &net.UDPAddr{IP: net.ParseIP("192.168.1.66")}, nil)
+
+	found := map[string]*Device{}
+	for _, device := range receiver.GetPeers() {
+		found[device.IP] = device
+	}
+
+	genuine := found["192.168.1.20"]
+	if genuine == nil || !genuine.Signed() || genuine.Fingerprint != victim.Fingerprint() || genuine.ID != DeviceID(victim.Fingerprint()) {
+		t.Errorf("genuine announcement: got %+v, want it signed with the victim's fingerprint", genuine)
+	}
+	replayed := found["192.168.1.66"]
+	if replayed == nil {
+		t.Fatal("replayed announcement was not listed")
+	}
+	if replayed.Signed() || replayed.Fingerprint != "" || replayed.ID != "" {
+		t.Errorf("replayed announcement: got signed %v, fingerprint %q, ID %q; want none", replayed.Signed(), replayed.Fingerprint, replayed.ID)
+	}
+}
@@ -0,0 +1,154 @@
+package discovery
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxSweepHosts caps the number of addresses probed per network so that a
+// misconfigured CIDR like /8 doesn't flood the network
+const maxSweepHosts = 4096
+
+// sweepTimeout bounds each HTTP probe during a sweep
+const sweepTimeout = time.Second
+
+// Sweep modes
+const (
+	SweepUDP  = "udp"  // unicast discovery datagrams, answered like broadcasts
+	SweepHTTP = "http" // GET /api/info on the transfer port
+)
+
+// sweepTargets returns the addresses to probe: the configured CIDRs, or the
+// /24 around each local interface address
+func (s *Service) sweepTargets() []net.IP {
+	var networks []*net.IPNet
+	if len(s.sweepCIDRs) > 0 {
+		for _, cidr := range s.sweepCIDRs {
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil || network.IP.To4() == nil {
+				fmt.Printf("Ignoring invalid sweep CIDR %q\n", cidr)
+				continue
+			}
+			networks = append(networks, network)
+		}
+	} else {
+		for _, li := range s.interfaces() {
+			network := li.Network
+			if ones, _ := network.Mask.Size(); ones < 24 {
+				mask := net.CIDRMask(24, 32)
+				network = &net.IPNet{IP: li.IP.Mask(mask), Mask: mask}
+			}
+			networks = append(networks, network)
+		}
+	}
+
+	local := make(map[string]bool)
+	for _, li := range s.interfaces() {
+		local[li.IP.String()] = true
+	}
+
+	seen := make(map[string]bool)
+	var targets []net.IP
+	for _, network := range networks {
+		base := binary.BigEndian.Uint32(network.IP.To4())
+		ones, bits := network.Mask.Size()
+		size := uint32(1) << uint(bits-ones)
+
+		first, last := uint32(1), size-1 // skip network and broadcast addresses
+		if size <= 2 {
+			first, last = 0, size
+		}
+		if last-first > maxSweepHosts {
+			last = first + maxSweepHosts
+		}
+
+		for i := first; i < last; i++ {
+			ip := make(net.IP, net.IPv4len)
+			binary.BigEndian.PutUint32(ip, base+i)
+			if local[ip.String()] || seen[ip.String()] {
+				continue
+			}
+			seen[ip.String()] = true
+			targets = append(targets, ip)
+		}
+	}
+
+	return targets
+}
+
+// Sweep actively probes every address of the local networks for peers whose
+// broadcast and multicast traffic is filtered. Probes are paced to the
+// configured rate and at most the configured number run at once.
+func (s *Service) Sweep() {
+	targets := s.sweepTargets()
+	if len(targets) == 0 {
+		return
+	}
+
+	fmt.Printf("Sweeping %d addresses for peers (%s)\n", len(targets), s.sweepMode)
+
+	ticker := time.NewTicker(time.Second / time.Duration(s.sweepRate))
+	defer ticker.Stop()
+
+	sem := make(chan struct{}, s.sweepConcurrency)
+	var wg sync.WaitGroup
+
+	for _, ip := range targets {
+		select {
+		case <-ticker.C:
+		case <-s.stopChan:
+			wg.Wait()
+			return
+		}
+
+		if s.sweepMode == SweepHTTP {
+			sem <- struct{}{}
+			wg.Add(1)
+			go func(ip net.IP) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				s.probeHTTP(ip)
+			}(ip)
+			continue
+		}
+
+		dest := &net.UDPAddr{IP: ip, Port: s.udpPort}
+		if err := s.sendDiscover(s.conn, s.localIPFor(ip), dest); err != nil {
+			fmt.Printf("Error probing %s: %v\n", ip, err)
+		}
+	}
+
+	wg.Wait()
+}
+
+// probeHTTP asks a single address for its device info and records it as a peer
+func (s *Service) probeHTTP(ip net.IP) {
+	client := &http.Client{Timeout: sweepTimeout}
+	info, err := fetchInfo(client, ip.String(), s.httpPort)
+	if err != nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := ip.String()
+	if existing, ok := s.peers[key]; ok && existing.Pinned {
+		existing.applyInfo(info)
+		existing.LastSeen = time.Now()
+		return
+	}
+
+	device := &Device{
+		IP:       ip.String(),
+		Port:     info.Port,
+		LastSeen: time.Now(),
+	}
+	device.applyInfo(info)
+	s.peers[key] = device
+	fmt.Printf("Discovered device: %s (%s:%d)\n", info.Name, ip, info.Port)
+}
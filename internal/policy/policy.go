@@ -0,0 +1,151 @@
+package policy
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strings"
+	"sync"
+
+	"localsend/internal/config"
+)
+
+// Reasons a peer is refused, sent to it in 403 responses
+const (
+	ReasonBlocked    = "blocked"     // matches a block rule
+	ReasonNotAllowed = "not_allowed" // an allowlist exists and no rule matches
+)
+
+// Peer is what is known about a device when a policy is consulted
+type Peer struct {
+	Fingerprint string // empty unless the device proved its key
+	IP          string
+	Name        string
+}
+
+// Decision is the outcome of checking a peer against the policy
+type Decision struct {
+	Allowed bool               `json:"allowed"`
+	Reason  string             `json:"reason,omitempty"`
+	Rule    *config.PolicyRule `json:"rule,omitempty"`
+}
+
+// Policy decides which devices may be seen and may send to us. Block rules
+// win over allow rules; when there are allow rules, only matching devices
+// are allowed.
+type Policy struct {
+	allow []config.PolicyRule
+	block []config.PolicyRule
+	mutex sync.RWMutex
+}
+
+// New creates a policy from allow and block rules, warning about rules from
+// the configuration file that Validate would refuse
+func New(allow, block []config.PolicyRule) *Policy {
+	for _, rule := range append(append([]config.PolicyRule{}, allow...), block...) {
+		if err := Validate(&rule); err != nil {
+			fmt.Printf("Invalid policy rule %+v: %v\n", rule, err)
+		}
+	}
+
+	p := &Policy{}
+	p.Set(allow, block)
+	return p
+}
+
+// Set replaces the rules of the policy
+func (p *Policy) Set(allow, block []config.PolicyRule) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.allow = append([]config.PolicyRule{}, allow...)
+	p.block = append([]config.PolicyRule{}, block...)
+}
+
+// Check decides whether peer is allowed
+func (p *Policy) Check(peer Peer) Decision {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	for i := range p.block {
+		if Matches(&p.block[i], peer) {
+			rule := p.block[i]
+			return Decision{Reason: ReasonBlocked, Rule: &rule}
+		}
+	}
+
+	if len(p.allow) == 0 {
+		return Decision{Allowed: true}
+	}
+	for i := range p.allow {
+		if Matches(&p.allow[i], peer) {
+			rule := p.allow[i]
+			return Decision{Allowed: true, Rule: &rule}
+		}
+	}
+	return Decision{Reason: ReasonNotAllowed}
+}
+
+// Matches reports whether every field set in rule matches peer
+func Matches(rule *config.PolicyRule, peer Peer) bool {
+	if rule.ID == "" && rule.Fingerprint == "" && rule.IP == "" && rule.Name == "" {
+		return false
+	}
+
+	// A short ID would match many keys, so an invalid one matches none
+	if rule.ID != "" {
+		id := strings.ToLower(rule.ID)
+		if peer.Fingerprint == "" || !config.ValidDeviceID(id) || !strings.HasPrefix(peer.Fingerprint, id) {
+			return false
+		}
+	}
+	if rule.Fingerprint != "" && !strings.EqualFold(rule.Fingerprint, peer.Fingerprint) {
+		return false
+	}
+	if rule.IP != "" && !matchIP(rule.IP, peer.IP) {
+		return false
+	}
+	if rule.Name != "" {
+		ok, _ := path.Match(strings.ToLower(rule.Name), strings.ToLower(peer.Name))
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// matchIP matches an address against an address or CIDR
+func matchIP(pattern, address string) bool {
+	host, _, _ := strings.Cut(address, "%")
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	if _, network, err := net.ParseCIDR(pattern); err == nil {
+		return network.Contains(ip)
+	}
+	return net.ParseIP(pattern).Equal(ip)
+}
+
+// Validate checks that a rule sets at least one field, that its ID has at
+// least 16 hex characters and that its IP and name patterns are well formed
+func Validate(rule *config.PolicyRule) error {
+	if rule.ID == "" && rule.Fingerprint == "" && rule.IP == "" && rule.Name == "" {
+		return fmt.Errorf("rule must set id, fingerprint, ip or name")
+	}
+	if rule.ID != "" && !config.ValidDeviceID(strings.ToLower(rule.ID)) {
+		return fmt.Errorf("invalid device ID %q: use at least 16 hex characters of the fingerprint", rule.ID)
+	}
+	if rule.IP != "" {
+		if _, _, err := net.ParseCIDR(rule.IP); err != nil && net.ParseIP(rule.IP) == nil {
+			return fmt.Errorf("invalid IP or CIDR %q", rule.IP)
+		}
+	}
+	if rule.Name != "" {
+		if _, err := path.Match(rule.Name, ""); err != nil {
+			return fmt.Errorf("invalid name pattern %q", rule.Name)
+		}
+	}
+	return nil
+}
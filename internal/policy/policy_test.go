@@ -0,0 +1,42 @@
+package policy
+
+import (
+	"testing"
+
+	"localsend/internal/config"
+)
+
+func TestValidateID(t *testing.T) {
+	tests := []struct {
+		id string
+		ok bool
+	}{
+		{"3f9a0c5e8b1d7e42", true},
+		{"3F9A0C5E8B1D7E42", true},
+		{"3f9a0c5e8b1d7e42a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718", true},
+		{"a", false},
+		{"3f9a0c5e8b1d7e4", false},
+		{"3f9a0c5e8b1d7e4z", false},
+		{"3f9a0c5e8b1d7e42a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f6071800", false},
+	}
+
+	for _, tt := range tests {
+		err := Validate(&config.PolicyRule{ID: tt.id})
+		if (err == nil) != tt.ok {
+			t.Errorf("Validate(id %q) = %v, want ok %v", tt.id, err, tt.ok)
+		}
+	}
+}
+
+func TestMatchesShortID(t *testing.T) {
+	peer := Peer{Fingerprint: "3f9a0c5e8b1d7e42a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718", IP: "192.168.1.20"}
+
+	if !Matches(&config.PolicyRule{ID: "3F9A0C5E8B1D7E42"}, peer) {
+		t.Error("rule with the device's ID does not match")
+	}
+	for _, id := range []string{"3", "3f9a", "3f9a0c5e8b1d7e4"} {
+		if Matches(&config.PolicyRule{ID: id}, peer) {
+			t.Errorf("rule with short ID %q matches", id)
+		}
+	}
+}
@@ -0,0 +1,443 @@
+// Package qrcode implements a minimal QR code encoder used to show share
+// links in the web interface. It supports byte mode with error correction
+// level M for versions 1 to 10, which is plenty for LAN URLs.
+package qrcode
+
+import (
+	"fmt"
+	"strings"
+)
+
+// blockLayout describes the error correction structure of one version
+type blockLayout struct {
+	ecPerBlock  int
+	group1      int // number of blocks in group 1
+	group1Data  int // data codewords per group 1 block
+	group2      int // number of blocks in group 2
+	group2Data  int // data codewords per group 2 block
+	alignCoords []int
+}
+
+// layouts holds level M block layouts for versions 1 to 10
+var layouts = []blockLayout{
+	{10, 1, 16, 0, 0, nil},
+	{16, 1, 28, 0, 0, []int{6, 18}},
+	{26, 1, 44, 0, 0, []int{6, 22}},
+	{18, 2, 32, 0, 0, []int{6, 26}},
+	{24, 2, 43, 0, 0, []int{6, 30}},
+	{16, 4, 27, 0, 0, []int{6, 34}},
+	{18, 4, 31, 0, 0, []int{6, 22, 38}},
+	{22, 2, 38, 2, 39, []int{6, 24, 42}},
+	{22, 3, 36, 2, 37, []int{6, 26, 46}},
+	{26, 4, 43, 1, 44, []int{6, 28, 50}},
+}
+
+// Code is an encoded QR symbol
+type Code struct {
+	Size    int
+	modules [][]bool
+}
+
+// Dark reports whether the module at column x, row y is dark
+func (c *Code) Dark(x, y int) bool {
+	return c.modules[y][x]
+}
+
+// Encode encodes data as a QR code, picking the smallest version that fits
+func Encode(data string) (*Code, error) {
+	for i, layout := range layouts {
+		version := i + 1
+		capacity := layout.group1*layout.group1Data + layout.group2*layout.group2Data
+		countBits := 8
+		if version >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+len(data)*8 <= capacity*8 {
+			return encode([]byte(data), version, layout, capacity, countBits), nil
+		}
+	}
+
+	return nil, fmt.Errorf("data too long for QR code: %d bytes", len(data))
+}
+
+// SVG renders the code as a scalable SVG image with a quiet zone
+func (c *Code) SVG() string {
+	const border = 4
+	dim := c.Size + border*2
+
+	var path strings.Builder
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.modules[y][x] {
+				fmt.Fprintf(&path, "M%d,%dh1v1h-1z", x+border, y+border)
+			}
+		}
+	}
+
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+
+		`<rect width="100%%" height="100%%" fill="#ffffff"/><path d="%s" fill="#000000"/></svg>`,
+		dim, dim, path.String())
+}
+
+// encode builds the symbol for a version known to fit the data
+func encode(data []byte, version int, layout blockLayout, capacity, countBits int) *Code {
+	// Byte mode segment, terminator and padding
+	var bits bitBuffer
+	bits.append(0x4, 4)
+	bits.append(len(data), countBits)
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	terminator := capacity*8 - len(bits)
+	if terminator > 4 {
+		terminator = 4
+	}
+	bits.append(0, terminator)
+	bits.append(0, (8-len(bits)%8)%8)
+
+	codewords := bits.bytes()
+	for pad := byte(0xEC); len(codewords) < capacity; pad ^= 0xEC ^ 0x11 {
+		codewords = append(codewords, pad)
+	}
+
+	final := interleave(codewords, layout)
+
+	size := version*4 + 17
+	q := &symbol{size: size, modules: newGrid(size), function: newGrid(size)}
+	q.drawFunctionPatterns(version, layout)
+	q.drawCodewords(final)
+
+	// Pick the mask with the lowest penalty
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask)
+		q.drawFormatBits(mask)
+		if penalty := q.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		q.applyMask(mask)
+	}
+	q.applyMask(best)
+	q.drawFormatBits(best)
+
+	return &Code{Size: size, modules: q.modules}
+}
+
+// interleave splits data into blocks, appends error correction and interleaves
+func interleave(data []byte, layout blockLayout) []byte {
+	var blocks, ecBlocks [][]byte
+	offset := 0
+	for i := 0; i < layout.group1+layout.group2; i++ {
+		n := layout.group1Data
+		if i >= layout.group1 {
+			n = layout.group2Data
+		}
+		block := data[offset : offset+n]
+		offset += n
+		blocks = append(blocks, block)
+		ecBlocks = append(ecBlocks, reedSolomon(block, layout.ecPerBlock))
+	}
+
+	var result []byte
+	maxData := layout.group1Data
+	if layout.group2Data > maxData {
+		maxData = layout.group2Data
+	}
+	for i := 0; i < maxData; i++ {
+		for _, block := range blocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+	for i := 0; i < layout.ecPerBlock; i++ {
+		for _, block := range ecBlocks {
+			result = append(result, block[i])
+		}
+	}
+
+	return result
+}
+
+// bitBuffer accumulates bits most significant first
+type bitBuffer []bool
+
+func (b *bitBuffer) append(value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		*b = append(*b, (value>>uint(i))&1 == 1)
+	}
+}
+
+func (b bitBuffer) bytes() []byte {
+	result := make([]byte, len(b)/8)
+	for i, bit := range b {
+		if bit {
+			result[i/8] |= 0x80 >> uint(i%8)
+		}
+	}
+	return result
+}
+
+// symbol is the module grid while it is being built
+type symbol struct {
+	size     int
+	modules  [][]bool
+	function [][]bool
+}
+
+func newGrid(size int) [][]bool {
+	grid := make([][]bool, size)
+	for i := range grid {
+		grid[i] = make([]bool, size)
+	}
+	return grid
+}
+
+// set marks a function module at column x, row y
+func (q *symbol) set(x, y int, dark bool) {
+	q.modules[y][x] = dark
+	q.function[y][x] = true
+}
+
+func (q *symbol) drawFunctionPatterns(version int, layout blockLayout) {
+	// Timing patterns
+	for i := 0; i < q.size; i++ {
+		q.set(6, i, i%2 == 0)
+		q.set(i, 6, i%2 == 0)
+	}
+
+	// Finder patterns with separators
+	q.drawFinder(3, 3)
+	q.drawFinder(q.size-4, 3)
+	q.drawFinder(3, q.size-4)
+
+	// Alignment patterns, skipping the three finder corners
+	coords := layout.alignCoords
+	for i, cy := range coords {
+		for j, cx := range coords {
+			if (i == 0 && j == 0) || (i == 0 && j == len(coords)-1) || (i == len(coords)-1 && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					q.set(cx+dx, cy+dy, maxAbs(dx, dy) != 1)
+				}
+			}
+		}
+	}
+
+	// Reserve format areas (drawn for real once the mask is chosen)
+	q.drawFormatBits(0)
+
+	// Version information
+	if version >= 7 {
+		rem := version
+		for i := 0; i < 12; i++ {
+			rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+		}
+		bits := version<<12 | rem
+		for i := 0; i < 18; i++ {
+			dark := (bits>>uint(i))&1 == 1
+			a := q.size - 11 + i%3
+			b := i / 3
+			q.set(a, b, dark)
+			q.set(b, a, dark)
+		}
+	}
+}
+
+// drawFinder draws a finder pattern centred on x, y along with its separator
+func (q *symbol) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || xx >= q.size || yy < 0 || yy >= q.size {
+				continue
+			}
+			dist := maxAbs(dx, dy)
+			q.set(xx, yy, dist != 2 && dist != 4)
+		}
+	}
+}
+
+// drawFormatBits writes both copies of the format information for level M
+func (q *symbol) drawFormatBits(mask int) {
+	data := mask // level M is encoded as 00
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return (bits>>uint(i))&1 == 1 }
+
+	for i := 0; i <= 5; i++ {
+		q.set(8, i, bit(i))
+	}
+	q.set(8, 7, bit(6))
+	q.set(8, 8, bit(7))
+	q.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.set(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		q.set(q.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.set(8, q.size-15+i, bit(i))
+	}
+	q.set(8, q.size-8, true) // dark module
+}
+
+// drawCodewords places data in the zigzag order, leaving remainder bits light
+func (q *symbol) drawCodewords(data []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < q.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = q.size - 1 - vert
+				}
+				if q.function[y][x] || i >= len(data)*8 {
+					continue
+				}
+				q.modules[y][x] = (data[i/8]>>uint(7-i%8))&1 == 1
+				i++
+			}
+		}
+	}
+}
+
+// applyMask flips data modules according to the mask; applying twice undoes it
+func (q *symbol) applyMask(mask int) {
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if q.function[y][x] {
+				continue
+			}
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert {
+				q.modules[y][x] = !q.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores the symbol using the mask evaluation rules of the standard
+func (q *symbol) penalty() int {
+	score := 0
+	at := func(x, y int, transpose bool) bool {
+		if transpose {
+			return q.modules[x][y]
+		}
+		return q.modules[y][x]
+	}
+
+	for _, transpose := range []bool{false, true} {
+		for y := 0; y < q.size; y++ {
+			// Runs of five or more same-coloured modules
+			run := 1
+			for x := 1; x < q.size; x++ {
+				if at(x, y, transpose) == at(x-1, y, transpose) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					score += run - 2
+				}
+				run = 1
+			}
+			if run >= 5 {
+				score += run - 2
+			}
+
+			// Finder-like 1:1:3:1:1 patterns next to four light modules
+			for x := 0; x+10 < q.size; x++ {
+				pattern := []bool{true, false, true, true, true, false, true}
+				match := true
+				for k, dark := range pattern {
+					if at(x+k, y, transpose) != dark {
+						match = false
+						break
+					}
+				}
+				if !match {
+					continue
+				}
+				lightBefore := x >= 4
+				for k := 1; k <= 4 && lightBefore; k++ {
+					lightBefore = !at(x-k, y, transpose)
+				}
+				lightAfter := true
+				for k := 7; k <= 10; k++ {
+					lightAfter = lightAfter && !at(x+k, y, transpose)
+				}
+				if lightBefore || lightAfter {
+					score += 40
+				}
+			}
+		}
+	}
+
+	// 2x2 blocks of the same colour
+	dark := 0
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if q.modules[y][x] {
+				dark++
+			}
+			if x+1 < q.size && y+1 < q.size {
+				c := q.modules[y][x]
+				if c == q.modules[y][x+1] && c == q.modules[y+1][x] && c == q.modules[y+1][x+1] {
+					score += 3
+				}
+			}
+		}
+	}
+
+	// Balance of dark and light modules
+	total := q.size * q.size
+	deviation := dark*20 - total*10
+	if deviation < 0 {
+		deviation = -deviation
+	}
+	score += (deviation + total - 1) / total * 10
+
+	return score
+}
+
+func maxAbs(a, b int) int {
+	if a < 0 {
+		a = -a
+	}
+	if b < 0 {
+		b = -b
+	}
+	if a > b {
+		return a
+	}
+	return b
+}
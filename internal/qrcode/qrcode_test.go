@@ -0,0 +1,162 @@
+package qrcode
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// The expected symbols were produced by an independent encoder at level M
+// in byte mode; the last one is version 8, with version information and
+// blocks of two sizes
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		data string
+		want []string
+	}{
+		{
+			data: "hello world",
+			want: []string{
+				"#######..#.##.#######",
+				"#.....#...#...#.....#",
+				"#.###.#.####..#.###.#",
+				"#.###.#.###.#.#.###.#",
+				"#.###.#.#.#.#.#.###.#",
+				"#.....#.#..#..#.....#",
+				"#######.#.#.#.#######",
+				"........#.#..........",
+				"#.#####..#.#..#####..",
+				".##.##.#.#.########.#",
+				"#.#.####.##.###..###.",
+				"#.#..#...#.###..###..",
+				"...#.#####..###.....#",
+				"........#.#.#...##..#",
+				"#######....#..#...##.",
+				"#.....#.#....#.#.####",
+				"#.###.#.#..#..##....#",
+				"#.###.#.##..######...",
+				"#.###.#.##..#..#..#..",
+				"#.....#..##.##..###..",
+				"#######.##.##.#.#..#.",
+			},
+		},
+		{
+			data: "https://example.com",
+			want: []string{
+				"#######....###..#.#######",
+				"#.....#...#..####.#.....#",
+				"#.###.#.##.#..#...#.###.#",
+				"#.###.#.#....###..#.###.#",
+				"#.###.#.###..#..#.#.###.#",
+				"#.....#.#..#..##..#.....#",
+				"#######.#.#.#.#.#.#######",
+				"........#.....#.#........",
+				"#.#####.....#.....#####..",
+				".#..##..#.##.#...#.#...#.",
+				"#####.#.##...####..#.#.##",
+				"##.###..#.##.#.##.##....#",
+				".###..#....##.##.##.#.###",
+				"#####...#.#.....#..#.#.#.",
+				"#.....##..###..#..####.##",
+				"#..#...#...#..#######...#",
+				"#.#..##.####....#####.#..",
+				"........##..#####...##...",
+				"#######......##.#.#.#.###",
+				"#.....#.##..##..#...##.#.",
+				"#.###.#.###.#.#######.#.#",
+				"#.###.#.#......#.##.#####",
+				"#.###.#.#####..#.....##.#",
+				"#.....#....#..#.##.###..#",
+				"#######.##.#.....########",
+			},
+		},
+		{
+			data: strings.Repeat("x", 150),
+			want: []string{
+				"#######....######.##....##.#.##.....#...#.#######",
+				"#.....#....#..#....##.#..#####..#.#...###.#.....#",
+				"#.###.#.##.....##...####..#.#..#####...##.#.###.#",
+				"#.###.#.#.##....####.#.##.....##.#.###.#..#.###.#",
+				"#.###.#.##..###.###..#######.##.....##....#.###.#",
+				"#.....#.#.###..#..##..#...####..#.#..##...#.....#",
+				"#######.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#######",
+				"........#...##.####...#...#.#..#####..###........",
+				"#.#####...##.#....###.#####...##.#.##...#.#####..",
+				"#.#.##.#.##.#...#..#.##.##.#.##.....##...##..###.",
+				".#.##.#.#.##.........#...#####..#.#..###.#..##.##",
+				"###.##..##...####.#.#..#..#.#..#####..###..##...#",
+				"####..###.....###.#...###.....##.#.##...#.##..#..",
+				"#..##..#.#.#.....#.####.##.#.##.....##...##..###.",
+				"#.#...#..#..#.#.#.#.##...#####..#.#..###.#..##.##",
+				"#....#.#.....##....##..#..#.#..#####..###..##...#",
+				"..#..##.#.######.#.##.###.....##.#.##...#.##..#..",
+				"#......#...##.#..#.#.##.##.#.##.....##...##..###.",
+				"#.#...##.....#..###..#...#####..#.#..###.#..##.##",
+				"#.......###.#.#...#.#..#..#.#..#####..###..##...#",
+				"##.#####.#....#..#.##.###.....##.#.##...#.##..#..",
+				"####.#.#..#....#.#.#.##.##.#.##.....##...##..###.",
+				"..#######..#.#.####..#########..#.#..#########.##",
+				"....#...#....#...#..###...#.#..#####..###...#...#",
+				".#..#.#.##.##..#..#####.#.#...##.#.##..##.#.#.#..",
+				".##.#...#.#.#.....##..#...##.##.....##..#...####.",
+				".##.#####.###.##.##...########..#.#..##.######.##",
+				"###.......#.##...#..#.#..##.#..#####..#.##..#...#",
+				".#..#.###.##...##....#.#..#...##.#.##..#...##.#..",
+				"#.#..#..###......#...#.##..#.##.....##.#..##.###.",
+				"#.##..##..##.#.#.#....#.##.###..#.#..##.###..#.##",
+				".###.#.##..#.###..#.#.#..##.#..#####..#.##..#....",
+				".#.#..#..###....#.####.#..#...##.#.##..#...##.###",
+				"#.####..#.#.#..#...#.#.##..#.##.....##.#..##.###.",
+				"..##..#.#..#....##....#.##.###..#.#..##.###..#.##",
+				"#.#.#..#..###.......#.#..##.#..#####..#.##..#...#",
+				"###.#####.#...###..###.#..#...##.#.##..#...##.#..",
+				"##.##..#.###.#.#..##.#.##..#.##.....##.#..##.###.",
+				".#...####.#....##.....#.##.###..#.#..##.###..#.##",
+				".###...##.#.##.####.#.#..##.#..#####..#.##..#...#",
+				"###...#.###...##.##########...##.#.##..######.#..",
+				"........#...#.####.#.##...##.##.....##.##...####.",
+				"#######..#..##..#.#..##.#.####..#.#..####.#.##.##",
+				"#.....#.##.#....###.###...#.#..#####..###...#...#",
+				"#.###.#.#.#.###..####.#####...##.#.##...#####.#..",
+				"#.###.#.###..#.#..##..##..##.##.....##..#..######",
+				"#.###.#.#..##.#####..####..###..#.#..##...##.#...",
+				"#.....#...##.#.#....##..##..#..#####..##.##.....#",
+				"#######.#.#.##.#.####....##...##.#.##..###..#.###",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		code, err := Encode(tt.data)
+		if err != nil {
+			t.Fatalf("Encode(%q): %v", tt.data, err)
+		}
+		if code.Size != len(tt.want) {
+			t.Fatalf("Encode(%q): size %d, want %d", tt.data, code.Size, len(tt.want))
+		}
+		for y, row := range tt.want {
+			for x := range row {
+				if code.Dark(x, y) != (row[x] == '#') {
+					t.Errorf("Encode(%q): module %d,%d differs from the expected symbol", tt.data, x, y)
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeTooLong(t *testing.T) {
+	if _, err := Encode(strings.Repeat("x", 214)); err == nil {
+		t.Error("Encode of 214 bytes succeeded, want an error")
+	}
+}
+
+// The error correction codewords of the common version 1-M "HELLO WORLD"
+// worked example (alphanumeric data, already split into codewords)
+func TestReedSolomon(t *testing.T) {
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+
+	if got := reedSolomon(data, 10); !bytes.Equal(got, want) {
+		t.Errorf("reedSolomon = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,54 @@
+package qrcode
+
+// gfMultiply multiplies two elements of GF(256) modulo x^8+x^4+x^3+x^2+1
+func gfMultiply(x, y byte) byte {
+	var z byte
+	for i := 7; i >= 0; i-- {
+		carry := z >> 7
+		z <<= 1
+		if carry == 1 {
+			z ^= 0x1D
+		}
+		if (y>>uint(i))&1 == 1 {
+			z ^= x
+		}
+	}
+	return z
+}
+
+// generator returns the Reed-Solomon generator polynomial of the given degree,
+// highest coefficient first with the leading 1 omitted
+func generator(degree int) []byte {
+	poly := make([]byte, degree)
+	poly[degree-1] = 1
+
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range poly {
+			poly[j] = gfMultiply(poly[j], root)
+			if j+1 < len(poly) {
+				poly[j] ^= poly[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+
+	return poly
+}
+
+// reedSolomon computes the error correction codewords for a data block
+func reedSolomon(data []byte, degree int) []byte {
+	gen := generator(degree)
+	result := make([]byte, degree)
+
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[degree-1] = 0
+		for i, coef := range gen {
+			result[i] ^= gfMultiply(coef, factor)
+		}
+	}
+
+	return result
+}
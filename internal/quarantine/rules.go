@@ -0,0 +1,95 @@
+package quarantine
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Actions taken on a received file
+const (
+	Allow      = "allow"      // move into the download directory
+	Deny       = "deny"       // delete it
+	Quarantine = "quarantine" // keep it in the quarantine folder
+)
+
+// SniffLen is how much of a file Classify looks at
+const SniffLen = 512
+
+// Rules decide what happens to received files. Patterns are extensions
+// (".exe") or MIME types, optionally with wildcards ("application/x-*").
+type Rules struct {
+	Allow      []string // when not empty, files matching nothing here are denied
+	Deny       []string
+	Quarantine []string
+}
+
+// Empty reports whether no rules are configured
+func (r *Rules) Empty() bool {
+	return len(r.Allow) == 0 && len(r.Deny) == 0 && len(r.Quarantine) == 0
+}
+
+// Classify returns the action for a file called name whose content starts
+// with head, together with the pattern that decided it
+func (r *Rules) Classify(name string, head []byte) (string, string) {
+	ext := strings.ToLower(filepath.Ext(name))
+	mimeType := Sniff(head)
+
+	if pattern, ok := match(r.Deny, ext, mimeType); ok {
+		return Deny, pattern
+	}
+	if pattern, ok := match(r.Quarantine, ext, mimeType); ok {
+		return Quarantine, pattern
+	}
+	if len(r.Allow) > 0 {
+		if pattern, ok := match(r.Allow, ext, mimeType); ok {
+			return Allow, pattern
+		}
+		return Deny, "not allowed: " + mimeType
+	}
+	return Allow, ""
+}
+
+// match returns the first pattern matching the extension or MIME type
+func match(patterns []string, ext, mimeType string) (string, bool) {
+	for _, pattern := range patterns {
+		p := strings.ToLower(strings.TrimSpace(pattern))
+		if strings.HasPrefix(p, ".") {
+			if p == ext {
+				return pattern, true
+			}
+			continue
+		}
+		if ok, _ := path.Match(p, mimeType); ok {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// Sniff returns the MIME type of content starting with head. Besides what
+// net/http recognises it detects executables and scripts, which it reports
+// as plain binary data or text.
+func Sniff(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte("MZ")):
+		return "application/x-msdownload"
+	case bytes.HasPrefix(head, []byte("\x7fELF")):
+		return "application/x-executable"
+	case bytes.HasPrefix(head, []byte{0xcf, 0xfa, 0xed, 0xfe}),
+		bytes.HasPrefix(head, []byte{0xce, 0xfa, 0xed, 0xfe}),
+		bytes.HasPrefix(head, []byte{0xca, 0xfe, 0xba, 0xbe}):
+		return "application/x-mach-binary"
+	case bytes.HasPrefix(head, []byte("#!")):
+		return "text/x-shellscript"
+	}
+
+	mimeType, _, err := mime.ParseMediaType(http.DetectContentType(head))
+	if err != nil {
+		return "application/octet-stream"
+	}
+	return mimeType
+}
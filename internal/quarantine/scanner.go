@@ -0,0 +1,78 @@
+package quarantine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Result is a scanner's verdict on a file
+type Result struct {
+	Clean  bool
+	Detail string // e.g. the name of the detected threat
+}
+
+// Scanner inspects a received file before it is moved into the download
+// directory. Files it doesn't find clean, or fails to scan, are quarantined.
+type Scanner interface {
+	Scan(ctx context.Context, path string) (Result, error)
+}
+
+// CommandScanner runs an external scanner such as clamdscan. "{file}" in
+// the arguments is replaced with the file's path, which is appended if no
+// argument contains it. Exit status 0 means clean and 1 means infected, as
+// with ClamAV; anything else is an error.
+type CommandScanner struct {
+	Command []string
+	Timeout time.Duration
+}
+
+// Scan runs the command on path
+func (c *CommandScanner) Scan(ctx context.Context, path string) (Result, error) {
+	if len(c.Command) == 0 {
+		return Result{}, fmt.Errorf("no scan command configured")
+	}
+
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	args := make([]string, 0, len(c.Command))
+	substituted := false
+	for _, arg := range c.Command[1:] {
+		if strings.Contains(arg, "{file}") {
+			arg = strings.ReplaceAll(arg, "{file}", path)
+			substituted = true
+		}
+		args = append(args, arg)
+	}
+	if !substituted {
+		args = append(args, path)
+	}
+
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.Command[0], args...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := cmd.Run()
+	detail := strings.TrimSpace(output.String())
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return Result{Clean: true, Detail: detail}, nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		return Result{Clean: false, Detail: detail}, nil
+	case ctx.Err() != nil:
+		return Result{}, fmt.Errorf("scan timed out")
+	default:
+		return Result{}, fmt.Errorf("scan failed: %v: %s", err, detail)
+	}
+}
@@ -0,0 +1,143 @@
+package ratelimit
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Ban is a temporarily blocked source
+type Ban struct {
+	Key    string    `json:"key"`
+	Reason string    `json:"reason"`
+	Until  time.Time `json:"until"`
+}
+
+// BanList blocks sources that misbehave repeatedly within a time window
+type BanList struct {
+	threshold int
+	window    time.Duration
+	duration  time.Duration
+	strikes   map[string]*strikes
+	bans      map[string]*Ban
+	lastPrune time.Time
+	mutex     sync.Mutex
+}
+
+type strikes struct {
+	count int
+	first time.Time
+}
+
+// NewBanList creates a ban list that bans a key for duration after threshold
+// strikes within window. A threshold of zero or less disables banning.
+func NewBanList(threshold int, window, duration time.Duration) *BanList {
+	return &BanList{
+		threshold: threshold,
+		window:    window,
+		duration:  duration,
+		strikes:   make(map[string]*strikes),
+		bans:      make(map[string]*Ban),
+		lastPrune: time.Now(),
+	}
+}
+
+// Strike records misbehaviour of key, such as a malformed packet or a failed
+// authentication, and reports whether key is banned as a result
+func (bl *BanList) Strike(key, reason string) bool {
+	if bl.threshold <= 0 {
+		return false
+	}
+
+	bl.mutex.Lock()
+	defer bl.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(bl.lastPrune) > pruneInterval {
+		bl.prune(now)
+	}
+
+	st, ok := bl.strikes[key]
+	if !ok || now.Sub(st.first) > bl.window {
+		st = &strikes{first: now}
+		bl.strikes[key] = st
+	}
+	st.count++
+
+	if st.count < bl.threshold {
+		return false
+	}
+
+	delete(bl.strikes, key)
+	bl.bans[key] = &Ban{
+		Key:    key,
+		Reason: reason,
+		Until:  now.Add(bl.duration),
+	}
+	fmt.Printf("Banned %s for %s: %s\n", key, bl.duration, reason)
+	return true
+}
+
+// prune drops strikes older than the window and expired bans, so that
+// sources seen once, possibly spoofed, don't accumulate
+func (bl *BanList) prune(now time.Time) {
+	for key, st := range bl.strikes {
+		if now.Sub(st.first) > bl.window {
+			delete(bl.strikes, key)
+		}
+	}
+	for key, ban := range bl.bans {
+		if now.After(ban.Until) {
+			delete(bl.bans, key)
+		}
+	}
+	bl.lastPrune = now
+}
+
+// Banned reports whether key is currently banned
+func (bl *BanList) Banned(key string) bool {
+	bl.mutex.Lock()
+	defer bl.mutex.Unlock()
+
+	ban, ok := bl.bans[key]
+	if !ok {
+		return false
+	}
+	if time.Now().After(ban.Until) {
+		delete(bl.bans, key)
+		return false
+	}
+	return true
+}
+
+// Unban lifts the ban on key
+func (bl *BanList) Unban(key string) bool {
+	bl.mutex.Lock()
+	defer bl.mutex.Unlock()
+
+	_, ok := bl.bans[key]
+	delete(bl.bans, key)
+	delete(bl.strikes, key)
+	return ok
+}
+
+// List returns the active bans, soonest to expire first
+func (bl *BanList) List() []Ban {
+	bl.mutex.Lock()
+	defer bl.mutex.Unlock()
+
+	now := time.Now()
+	bans := make([]Ban, 0, len(bl.bans))
+	for key, ban := range bl.bans {
+		if now.After(ban.Until) {
+			delete(bl.bans, key)
+			continue
+		}
+		bans = append(bans, *ban)
+	}
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].Until.Before(bans[j].Until)
+	})
+	return bans
+}
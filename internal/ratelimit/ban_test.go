@@ -0,0 +1,56 @@
+package ratelimit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBanListPrune(t *testing.T) {
+	bl := NewBanList(2, time.Minute, time.Hour)
+
+	// Many sources striking once, as spoofed packets would, and one banned
+	for i := 0; i < 1000; i++ {
+		bl.Strike(fmt.Sprintf("10.0.%d.%d", i/256, i%256), "malformed discovery packet")
+	}
+	bl.Strike("10.9.9.9", "malformed discovery packet")
+	if !bl.Strike("10.9.9.9", "malformed discovery packet") {
+		t.Fatal("second strike within the window did not ban")
+	}
+	if len(bl.strikes) != 1000 || len(bl.bans) != 1 {
+		t.Fatalf("got %d strikes and %d bans, want 1000 and 1", len(bl.strikes), len(bl.bans))
+	}
+
+	// Let the strikes and the ban expire
+	past := time.Now().Add(-2 * time.Hour)
+	for _, st := range bl.strikes {
+		st.first = past
+	}
+	bl.bans["10.9.9.9"].Until = past
+	bl.lastPrune = past
+
+	bl.Strike("10.1.1.1", "malformed discovery packet")
+	if len(bl.strikes) != 1 || bl.strikes["10.1.1.1"] == nil {
+		t.Errorf("got %d strikes after pruning, want only the new one", len(bl.strikes))
+	}
+	if len(bl.bans) != 0 {
+		t.Errorf("got %d bans after pruning, want none", len(bl.bans))
+	}
+}
+
+func TestBanListKeepsRecent(t *testing.T) {
+	bl := NewBanList(2, time.Minute, time.Hour)
+
+	bl.Strike("10.0.0.1", "malformed discovery packet")
+	bl.Strike("10.0.0.2", "malformed discovery packet")
+	bl.Strike("10.0.0.2", "malformed discovery packet")
+	bl.lastPrune = time.Now().Add(-2 * pruneInterval)
+
+	// Pruning keeps strikes within the window and bans that still run
+	if !bl.Strike("10.0.0.1", "malformed discovery packet") {
+		t.Error("strike within the window was pruned")
+	}
+	if !bl.Banned("10.0.0.2") {
+		t.Error("running ban was pruned")
+	}
+}
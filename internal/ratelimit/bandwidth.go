@@ -0,0 +1,174 @@
+package ratelimit
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// throttleChunk caps how much a throttled read or write moves at once, so
+// that waits stay short and evenly spaced
+const throttleChunk = 32 << 10
+
+// bandwidth is a token bucket of bytes. Transfers may overdraw it and then
+// wait until it is back in credit.
+type bandwidth struct {
+	tokens float64
+	last   time.Time
+}
+
+// take draws n bytes from the bucket refilled at rate bytes per second and
+// returns how long to wait before they may be used
+func (b *bandwidth) take(n int, rate int64, now time.Time) time.Duration {
+	burst := float64(rate) / 10
+	if burst < throttleChunk {
+		burst = throttleChunk
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * float64(rate)
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / float64(rate) * float64(time.Second))
+}
+
+// Throttle limits the bandwidth of transfers in total and per key, e.g. per
+// peer. Rates are in bytes per second, zero or less meaning no limit, and
+// may be changed while transfers are running.
+type Throttle struct {
+	total     int64
+	perKey    int64
+	all       bandwidth
+	buckets   map[string]*bandwidth
+	lastPrune time.Time
+	mutex     sync.Mutex
+}
+
+// NewThrottle creates a throttle allowing total bytes per second across all
+// keys and perKey bytes per second for each key
+func NewThrottle(total, perKey int64) *Throttle {
+	now := time.Now()
+	return &Throttle{
+		total:     total,
+		perKey:    perKey,
+		all:       bandwidth{last: now},
+		buckets:   make(map[string]*bandwidth),
+		lastPrune: now,
+	}
+}
+
+// SetRates changes the limits, taking effect for readers and writers
+// already in use too
+func (t *Throttle) SetRates(total, perKey int64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.total, t.perKey = total, perKey
+}
+
+// Rates returns the total and per-key limits
+func (t *Throttle) Rates() (int64, int64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.total, t.perKey
+}
+
+// Limited reports whether any limit is set
+func (t *Throttle) Limited() bool {
+	total, perKey := t.Rates()
+	return total > 0 || perKey > 0
+}
+
+// wait accounts n bytes moved for key and sleeps as long as the limits require
+func (t *Throttle) wait(key string, n int) {
+	if n <= 0 {
+		return
+	}
+
+	t.mutex.Lock()
+	now := time.Now()
+	if now.Sub(t.lastPrune) > pruneInterval {
+		for k, b := range t.buckets {
+			if now.Sub(b.last) > pruneInterval {
+				delete(t.buckets, k)
+			}
+		}
+		t.lastPrune = now
+	}
+
+	var delay time.Duration
+	if t.total > 0 {
+		delay = t.all.take(n, t.total, now)
+	}
+	if t.perKey > 0 {
+		b, ok := t.buckets[key]
+		if !ok {
+			b = &bandwidth{last: now}
+			t.buckets[key] = b
+		}
+		if d := b.take(n, t.perKey, now); d > delay {
+			delay = d
+		}
+	}
+	t.mutex.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// Reader limits reads from r to the bandwidth allowed for key
+func (t *Throttle) Reader(key string, r io.Reader) io.Reader {
+	return &throttledReader{throttle: t, key: key, reader: r}
+}
+
+// Writer limits writes to w to the bandwidth allowed for key
+func (t *Throttle) Writer(key string, w io.Writer) io.Writer {
+	return &throttledWriter{throttle: t, key: key, writer: w}
+}
+
+type throttledReader struct {
+	throttle *Throttle
+	key      string
+	reader   io.Reader
+}
+
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk && tr.throttle.Limited() {
+		p = p[:throttleChunk]
+	}
+	n, err := tr.reader.Read(p)
+	tr.throttle.wait(tr.key, n)
+	return n, err
+}
+
+type throttledWriter struct {
+	throttle *Throttle
+	key      string
+	writer   io.Writer
+}
+
+func (tw *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > throttleChunk && tw.throttle.Limited() {
+			chunk = chunk[:throttleChunk]
+		}
+		n, err := tw.writer.Write(chunk)
+		written += n
+		tw.throttle.wait(tw.key, n)
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
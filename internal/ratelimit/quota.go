@@ -0,0 +1,111 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Quota limits how much each key may use over a rolling window
+type Quota struct {
+	limit    int64
+	window   time.Duration
+	usage    map[string][]usage
+	reserved map[string]int64
+	mutex    sync.Mutex
+}
+
+type usage struct {
+	at     time.Time
+	amount int64
+}
+
+// NewQuota creates a quota of limit per key within window. A limit of zero
+// or less means no limit.
+func NewQuota(limit int64, window time.Duration) *Quota {
+	return &Quota{
+		limit:    limit,
+		window:   window,
+		usage:    make(map[string][]usage),
+		reserved: make(map[string]int64),
+	}
+}
+
+// Remaining returns how much key may still use, or -1 without a limit
+func (q *Quota) Remaining(key string) int64 {
+	if q.limit <= 0 {
+		return -1
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	remaining := q.limit - q.used(key) - q.reserved[key]
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// Reserve sets amount aside for key if it fits in what key may still use,
+// so that uses running at the same time can't exceed the limit together
+func (q *Quota) Reserve(key string, amount int64) bool {
+	if q.limit <= 0 || amount <= 0 {
+		return true
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.used(key)+q.reserved[key]+amount > q.limit {
+		return false
+	}
+	q.reserved[key] += amount
+	return true
+}
+
+// Release gives back amount of what was reserved for key
+func (q *Quota) Release(key string, amount int64) {
+	if q.limit <= 0 || amount <= 0 {
+		return
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.reserved[key] -= amount
+	if q.reserved[key] <= 0 {
+		delete(q.reserved, key)
+	}
+}
+
+// Add records that key used amount
+func (q *Quota) Add(key string, amount int64) {
+	if q.limit <= 0 || amount <= 0 {
+		return
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.usage[key] = append(q.usage[key], usage{at: time.Now(), amount: amount})
+}
+
+// used sums the usage of key within the window, dropping older entries
+func (q *Quota) used(key string) int64 {
+	cutoff := time.Now().Add(-q.window)
+	entries := q.usage[key]
+	for len(entries) > 0 && entries[0].at.Before(cutoff) {
+		entries = entries[1:]
+	}
+	if len(entries) == 0 {
+		delete(q.usage, key)
+		return 0
+	}
+	q.usage[key] = entries
+
+	var total int64
+	for _, e := range entries {
+		total += e.amount
+	}
+	return total
+}
@@ -0,0 +1,80 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// pruneInterval is how often idle buckets are dropped
+const pruneInterval = time.Minute
+
+// Limiter keeps a token bucket per key, e.g. per source IP
+type Limiter struct {
+	rate      float64 // tokens added per second
+	burst     float64
+	buckets   map[string]*bucket
+	lastPrune time.Time
+	mutex     sync.Mutex
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter creates a limiter allowing rate events per second per key, with
+// bursts of up to burst events. A rate of zero or less disables limiting.
+func NewLimiter(rate float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		rate:      rate,
+		burst:     float64(burst),
+		buckets:   make(map[string]*bucket),
+		lastPrune: time.Now(),
+	}
+}
+
+// Allow takes a token from the bucket of key, reporting whether one was left
+func (l *Limiter) Allow(key string) bool {
+	if l.rate <= 0 {
+		return true
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastPrune) > pruneInterval {
+		l.prune(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// prune drops buckets that have refilled completely
+func (l *Limiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastPrune = now
+}
@@ -0,0 +1,60 @@
+package ratelimit
+
+import "sync"
+
+// Slots caps how many operations run at once, per key and in total
+type Slots struct {
+	perKey int
+	total  int
+	active map[string]int
+	count  int
+	mutex  sync.Mutex
+}
+
+// NewSlots creates a limit of perKey concurrent operations per key and total
+// overall. Zero or less means no limit.
+func NewSlots(perKey, total int) *Slots {
+	return &Slots{
+		perKey: perKey,
+		total:  total,
+		active: make(map[string]int),
+	}
+}
+
+// Acquire takes a slot for key, reporting false if a limit is reached.
+// Every successful Acquire must be followed by Release.
+func (sl *Slots) Acquire(key string) bool {
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+
+	if sl.total > 0 && sl.count >= sl.total {
+		return false
+	}
+	if sl.perKey > 0 && sl.active[key] >= sl.perKey {
+		return false
+	}
+
+	sl.active[key]++
+	sl.count++
+	return true
+}
+
+// Release gives back a slot taken by Acquire
+func (sl *Slots) Release(key string) {
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+
+	sl.count--
+	sl.active[key]--
+	if sl.active[key] <= 0 {
+		delete(sl.active, key)
+	}
+}
+
+// Active returns the number of slots in use
+func (sl *Slots) Active() int {
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+
+	return sl.count
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"localsend/internal/config"
+)
+
+// tokenPlaceholder is replaced with the API token when serving the web interface
+const tokenPlaceholder = "{{API_TOKEN}}"
+
+// protectAdmin guards the admin listener against other websites and DNS rebinding.
+// Every request must use a known Host and, when sent by a browser, come from
+// the same origin. Control API requests must also carry the API token.
+func (s *HTTPServer) protectAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.allowedAdminHost(r.Host) {
+			fmt.Printf("Rejected admin request with Host %q from %s\n", r.Host, r.RemoteAddr)
+			http.Error(w, "Invalid Host header", http.StatusForbidden)
+			return
+		}
+
+		if !sameOrigin(r) {
+			fmt.Printf("Rejected cross-origin admin request from %q\n", r.Header.Get("Origin"))
+			http.Error(w, "Cross-origin request rejected", http.StatusForbidden)
+			return
+		}
+
+		// The admin interface must never be framed by another page
+		w.Header().Set("X-Frame-Options", "DENY")
+
+		if strings.HasPrefix(r.URL.Path, "/api/") && !s.validToken(r) {
+			http.Error(w, "Missing or invalid API token", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowedAdminHost reports whether the Host header names this machine
+func (s *HTTPServer) allowedAdminHost(hostport string) bool {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = strings.Trim(hostport, "[]")
+	}
+	host = strings.ToLower(host)
+
+	if host == "localhost" {
+		return true
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return true
+	}
+
+	if adminHost, _, err := net.SplitHostPort(s.config.AdminAddr); err == nil && strings.EqualFold(adminHost, host) {
+		return true
+	}
+	for _, allowed := range s.config.AdminHosts {
+		if strings.EqualFold(allowed, host) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sameOrigin rejects requests a browser made on behalf of another site
+func sameOrigin(r *http.Request) bool {
+	if r.Header.Get("Sec-Fetch-Site") == "cross-site" {
+		return false
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		// Not sent by non-browser clients and same-origin GET navigations
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// validToken checks the API token from the header, or from the query string
+// for plain GET requests such as download links and images
+func (s *HTTPServer) validToken(r *http.Request) bool {
+	token := r.Header.Get(config.TokenHeader)
+	if token == "" && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		token = r.URL.Query().Get("token")
+	}
+
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.config.APIToken)) == 1
+}
@@ -0,0 +1,447 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"localsend/internal/discovery"
+)
+
+// chatIndexFile stores the conversation with each peer inside downloadDir
+const chatIndexFile = ".localsend-chat.json"
+
+// chatHistory is how many entries are kept per peer
+const chatHistory = 500
+
+// At most chatMaxConversations conversations of about chatMaxBytes in total
+// are kept; the least recently active ones are dropped first
+const (
+	chatMaxConversations = 200
+	chatMaxBytes         = 8 << 20
+)
+
+// chatSaveDelay batches the changes written to the chat history file
+const chatSaveDelay = 2 * time.Second
+
+// Kinds of conversation entries
+const (
+	chatText = "text"
+	chatFile = "file"
+)
+
+// Status of outgoing entries; incoming files use the receive outcomes
+const (
+	chatDelivered = "delivered"
+	chatFailed    = "failed"
+)
+
+// ChatEntry is a message or file transfer in the conversation with a peer
+type ChatEntry struct {
+	ID       string    `json:"id"`
+	Outgoing bool      `json:"outgoing"`
+	Kind     string    `json:"kind"` // "text" or "file"
+	Text     string    `json:"text,omitempty"`
+	File     string    `json:"file,omitempty"`
+	Size     int64     `json:"size,omitempty"`
+	Status   string    `json:"status"`
+	Error    string    `json:"error,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// Conversation is the history with one peer, identified by its device ID or,
+// for peers that don't sign their requests, by IP
+type Conversation struct {
+	Peer    string       `json:"peer"`
+	Name    string       `json:"name"`
+	Host    string       `json:"host"`
+	Port    int          `json:"port,omitempty"` // 0 until the peer's port is known
+	Entries []*ChatEntry `json:"entries"`
+}
+
+// chatPeer identifies the other side of an entry
+type chatPeer struct {
+	Key  string
+	Name string
+	Host string
+	Port int
+}
+
+// chatLog keeps the conversations with every peer
+type chatLog struct {
+	path          string
+	conversations map[string]*Conversation
+	saveTimer     *time.Timer // pending write, nil if none
+	saveMutex     sync.Mutex  // held while writing, before mutex
+	mutex         sync.Mutex
+}
+
+// newChatLog loads the conversations from downloadDir
+func newChatLog(downloadDir string) *chatLog {
+	cl := &chatLog{
+		path:          filepath.Join(downloadDir, chatIndexFile),
+		conversations: make(map[string]*Conversation),
+	}
+
+	data, err := os.ReadFile(cl.path)
+	if err == nil {
+		if err := json.Unmarshal(data, &cl.conversations); err != nil {
+			fmt.Printf("Error reading chat history: %v\n", err)
+		}
+	}
+
+	return cl
+}
+
+// record adds entry to the conversation with peer, or replaces the entry
+// with the same ID and direction. It reports whether the entry was new.
+func (cl *chatLog) record(peer chatPeer, entry *ChatEntry) bool {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	conv, ok := cl.conversations[peer.Key]
+	if !ok {
+		conv = &Conversation{Peer: peer.Key}
+		cl.conversations[peer.Key] = conv
+	}
+	if peer.Name != "" {
+		conv.Name = peer.Name
+	}
+	if peer.Host != "" {
+		conv.Host = peer.Host
+	}
+	if peer.Port != 0 {
+		conv.Port = peer.Port
+	}
+
+	defer cl.save()
+	for i, existing := range conv.Entries {
+		if existing.ID == entry.ID && existing.Outgoing == entry.Outgoing {
+			conv.Entries[i] = entry
+			return false
+		}
+	}
+	conv.Entries = append(conv.Entries, entry)
+	if len(conv.Entries) > chatHistory {
+		conv.Entries = conv.Entries[len(conv.Entries)-chatHistory:]
+	}
+	cl.trim(peer.Key)
+	return true
+}
+
+// trim drops the least recently active conversations other than keep while
+// there are too many or they are too large. The caller must hold the mutex.
+func (cl *chatLog) trim(keep string) {
+	convs := make([]*Conversation, 0, len(cl.conversations))
+	var size int
+	for _, conv := range cl.conversations {
+		convs = append(convs, conv)
+		for _, entry := range conv.Entries {
+			size += entrySize(entry)
+		}
+	}
+	if len(convs) <= chatMaxConversations && size <= chatMaxBytes {
+		return
+	}
+
+	sort.Slice(convs, func(i, j int) bool {
+		return lastActive(convs[i]).Before(lastActive(convs[j]))
+	})
+	for _, conv := range convs {
+		if len(cl.conversations) <= chatMaxConversations && size <= chatMaxBytes {
+			return
+		}
+		if conv.Peer == keep {
+			continue
+		}
+		for _, entry := range conv.Entries {
+			size -= entrySize(entry)
+		}
+		delete(cl.conversations, conv.Peer)
+	}
+}
+
+// entrySize estimates the space an entry takes in the history file
+func entrySize(entry *ChatEntry) int {
+	return 128 + len(entry.ID) + len(entry.Text) + len(entry.File) + len(entry.Error)
+}
+
+// lastActive returns the time of the conversation's last entry
+func lastActive(conv *Conversation) time.Time {
+	if len(conv.Entries) == 0 {
+		return time.Time{}
+	}
+	return conv.Entries[len(conv.Entries)-1].Time
+}
+
+// fileScreened sets the name and status of the incoming file entry with id
+// once the file has been screened, unless it was already set
+func (cl *chatLog) fileScreened(key, id, name, status string) {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	conv, ok := cl.conversations[key]
+	if !ok {
+		return
+	}
+	for i := len(conv.Entries) - 1; i >= 0; i-- {
+		entry := conv.Entries[i]
+		if !entry.Outgoing && entry.ID == id && entry.Status == outcomeScanning {
+			// Entries may be encoded outside the lock, so replace rather than modify
+			updated := *entry
+			updated.File, updated.Status = name, status
+			conv.Entries[i] = &updated
+			cl.save()
+			return
+		}
+	}
+}
+
+// keyAt returns the peer of the conversation last held with host and port
+func (cl *chatLog) keyAt(host string, port int) string {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	for key, conv := range cl.conversations {
+		if conv.Host == host && conv.Port == port {
+			return key
+		}
+	}
+	return ""
+}
+
+// summaries returns each conversation with only its last entry, most
+// recently active first
+func (cl *chatLog) summaries() []map[string]interface{} {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	convs := make([]*Conversation, 0, len(cl.conversations))
+	for _, conv := range cl.conversations {
+		if len(conv.Entries) > 0 {
+			convs = append(convs, conv)
+		}
+	}
+	sort.Slice(convs, func(i, j int) bool {
+		return convs[i].Entries[len(convs[i].Entries)-1].Time.After(convs[j].Entries[len(convs[j].Entries)-1].Time)
+	})
+
+	summaries := make([]map[string]interface{}, 0, len(convs))
+	for _, conv := range convs {
+		summaries = append(summaries, map[string]interface{}{
+			"peer":    conv.Peer,
+			"name":    conv.Name,
+			"host":    conv.Host,
+			"port":    conv.Port,
+			"count":   len(conv.Entries),
+			"last":    conv.Entries[len(conv.Entries)-1],
+			"updated": conv.Entries[len(conv.Entries)-1].Time,
+		})
+	}
+	return summaries
+}
+
+// get returns a copy of the conversation with the peer
+func (cl *chatLog) get(key string) (Conversation, bool) {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	conv, ok := cl.conversations[key]
+	if !ok {
+		return Conversation{}, false
+	}
+	copied := *conv
+	copied.Entries = append([]*ChatEntry{}, conv.Entries...)
+	return copied, true
+}
+
+// remove deletes the conversation with the peer
+func (cl *chatLog) remove(key string) bool {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	if _, ok := cl.conversations[key]; !ok {
+		return false
+	}
+	delete(cl.conversations, key)
+	cl.save()
+	return true
+}
+
+// save schedules writing the conversations to disk, batching the changes
+// made within chatSaveDelay. The caller must hold the mutex.
+func (cl *chatLog) save() {
+	if cl.saveTimer == nil {
+		cl.saveTimer = time.AfterFunc(chatSaveDelay, cl.flush)
+	}
+}
+
+// flush writes the conversations to disk if a save is pending
+func (cl *chatLog) flush() {
+	cl.saveMutex.Lock()
+	defer cl.saveMutex.Unlock()
+
+	cl.mutex.Lock()
+	if cl.saveTimer == nil {
+		cl.mutex.Unlock()
+		return
+	}
+	cl.saveTimer.Stop()
+	cl.saveTimer = nil
+	data, err := json.MarshalIndent(cl.conversations, "", "  ")
+	cl.mutex.Unlock()
+
+	if err != nil {
+		fmt.Printf("Error encoding chat history: %v\n", err)
+		return
+	}
+	if err := os.WriteFile(cl.path, data, 0600); err != nil {
+		fmt.Printf("Error writing chat history: %v\n", err)
+	}
+}
+
+// newChatID returns a random entry ID
+func newChatID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// chatKey identifies a peer by the device ID of its key, or by IP
+func chatKey(fingerprint, ip string) string {
+	if fingerprint != "" {
+		return discovery.DeviceID(fingerprint)
+	}
+	return ip
+}
+
+// senderPeer is the peer that sent a request
+func senderPeer(sender *InboxSender, port int) chatPeer {
+	return chatPeer{
+		Key:  chatKey(sender.Fingerprint, sender.IP),
+		Name: sender.Name,
+		Host: sender.IP,
+		Port: port,
+	}
+}
+
+// targetPeer is the peer at host and port, identified by its device ID when
+// discovery knows it, or else by an earlier conversation at that address
+func (s *HTTPServer) targetPeer(host string, port int) chatPeer {
+	peer := chatPeer{Key: host, Host: host, Port: port}
+	for _, device := range s.discoveryService.GetPeers() {
+		if device.Host() == host && device.Port == port {
+			peer.Name = device.Name
+			if device.ID != "" {
+				peer.Key = device.ID
+				return peer
+			}
+		}
+	}
+	if key := s.chats.keyAt(host, port); key != "" {
+		peer.Key = key
+	}
+	return peer
+}
+
+// screenPeerFile screens a file received from another device and adds it to
+// the conversation with that device
+func (s *HTTPServer) screenPeerFile(path string, sender *InboxSender) (string, string) {
+	var size int64
+	if info, err := os.Stat(path); err == nil {
+		size = info.Size()
+	}
+
+	// The entry is recorded before screening, which may start a scan that
+	// finishes at any time
+	peer := senderPeer(sender, 0)
+	id := newChatID()
+	s.chats.record(peer, &ChatEntry{
+		ID:     id,
+		Kind:   chatFile,
+		File:   filepath.Base(path),
+		Size:   size,
+		Status: outcomeScanning,
+		Time:   time.Now(),
+	})
+
+	outcome, name := s.screenReceived(path, sender, func(outcome, name string) {
+		s.chats.fileScreened(peer.Key, id, name, outcome)
+	})
+	if outcome != outcomeScanning {
+		s.chats.fileScreened(peer.Key, id, name, outcome)
+	}
+	return outcome, name
+}
+
+// recordSent adds the outcome of sending files to a peer to its conversation
+func (s *HTTPServer) recordSent(peer chatPeer, results []*TransferResult) {
+	for _, result := range results {
+		entry := &ChatEntry{
+			ID:       newChatID(),
+			Outgoing: true,
+			Kind:     chatFile,
+			File:     result.File,
+			Size:     result.Bytes,
+			Status:   chatDelivered,
+			Time:     time.Now(),
+		}
+		if result.Error != "" {
+			entry.Status, entry.Error = chatFailed, result.Error
+		}
+		s.chats.record(peer, entry)
+	}
+}
+
+// handleChats lists the conversations
+func (s *HTTPServer) handleChats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"chats":   s.chats.summaries(),
+	})
+}
+
+// handleChat returns or deletes the conversation with a peer at
+// /api/chats/{peer}
+func (s *HTTPServer) handleChat(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/api/chats/")
+
+	switch r.Method {
+	case http.MethodGet:
+		conv, ok := s.chats.get(key)
+		if !ok {
+			http.Error(w, "Conversation not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"chat":    conv,
+		})
+	case http.MethodDelete:
+		if !s.chats.remove(key) {
+			http.Error(w, "Conversation not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
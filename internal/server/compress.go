@@ -0,0 +1,99 @@
+package server
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// Content encodings of upload bodies. zstd would compress faster but isn't
+// in the standard library, so only gzip is offered.
+const encodingGzip = "gzip"
+
+// reasonUnsupportedEncoding is sent for upload bodies we can't decode
+const reasonUnsupportedEncoding = "unsupported_encoding"
+
+// Compression is skipped for files smaller than minCompressSize, or whose
+// first entropySampleSize bytes look random already
+const (
+	minCompressSize   = 4 << 10
+	entropySampleSize = 64 << 10
+	maxEntropy        = 7.5 // bits per byte
+)
+
+// compressedExtensions are formats that are compressed already
+var compressedExtensions = map[string]bool{
+	".zip": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true,
+	".7z": true, ".rar": true, ".jar": true, ".apk": true, ".docx": true, ".xlsx": true,
+	".pptx": true, ".odt": true, ".epub": true, ".jpg": true, ".jpeg": true, ".png": true,
+	".gif": true, ".webp": true, ".heic": true, ".avif": true, ".mp3": true, ".aac": true,
+	".ogg": true, ".opus": true, ".flac": true, ".m4a": true, ".mp4": true, ".m4v": true,
+	".mkv": true, ".mov": true, ".webm": true, ".avi": true, ".pdf": true, ".dmg": true,
+}
+
+// shouldCompress decides whether compressing file is worth it, by its
+// extension and the entropy of a sample from its start
+func shouldCompress(file *os.File, size int64) bool {
+	if size < minCompressSize || compressedExtensions[strings.ToLower(filepath.Ext(file.Name()))] {
+		return false
+	}
+
+	sample := make([]byte, entropySampleSize)
+	n, err := file.ReadAt(sample, 0)
+	if err != nil && err != io.EOF {
+		return false
+	}
+	return entropy(sample[:n]) < maxEntropy
+}
+
+// entropy returns the Shannon entropy of data in bits per byte
+func entropy(data []byte) float64 {
+	if len(data) == 0 {
+		return 0
+	}
+
+	var counts [256]int
+	for _, b := range data {
+		counts[b]++
+	}
+
+	var h float64
+	for _, c := range counts {
+		if c == 0 {
+			continue
+		}
+		p := float64(c) / float64(len(data))
+		h -= p * math.Log2(p)
+	}
+	return h
+}
+
+// decodeBody returns the upload body of r with its Content-Encoding removed
+func decodeBody(r *http.Request) (io.ReadCloser, error) {
+	switch strings.ToLower(r.Header.Get("Content-Encoding")) {
+	case "", "identity":
+		return r.Body, nil
+	case encodingGzip:
+		return gzip.NewReader(r.Body)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", r.Header.Get("Content-Encoding"))
+	}
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	writer io.Writer
+	count  *int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.writer.Write(p)
+	atomic.AddInt64(cw.count, int64(n))
+	return n, err
+}
@@ -0,0 +1,26 @@
+//go:build windows
+
+package server
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var getDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// diskFree returns the bytes available to the current user on the volume
+// holding path
+func diskFree(path string) (int64, error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+
+	var available uint64
+	r, _, err := getDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&available)), 0, 0)
+	if r == 0 {
+		return 0, err
+	}
+	return int64(available), nil
+}
@@ -0,0 +1,457 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"localsend/internal/qrcode"
+)
+
+// dropRequestTTL is how long an unfinished drop request is kept around
+const dropRequestTTL = 10 * time.Minute
+
+// Drop request states
+const (
+	dropPending   = "pending"
+	dropAccepted  = "accepted"
+	dropRejected  = "rejected"
+	dropReceiving = "receiving"
+	dropCompleted = "completed"
+	dropFailed    = "failed"
+)
+
+// dropPage renders the public upload page
+var dropPage = template.Must(template.New("drop").Parse(dropHTML))
+
+// DropFile describes a file a visitor wants to upload
+type DropFile struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// DropRequest is an upload announced by a browser on the /drop page
+type DropRequest struct {
+	ID        string      `json:"id"`
+	Name      string      `json:"name"`
+	IP        string      `json:"ip"`
+	Files     []*DropFile `json:"files"`
+	TotalSize int64       `json:"totalSize"`
+	Received  int64       `json:"received"`
+	Status    string      `json:"status"`
+	Error     string      `json:"error,omitempty"`
+	CreatedAt time.Time   `json:"createdAt"`
+
+	progress *int64 // bytes received so far, updated while streaming
+}
+
+// dropStore keeps the drop requests in memory
+type dropStore struct {
+	requests map[string]*DropRequest
+	mutex    sync.Mutex
+}
+
+func newDropStore() *dropStore {
+	return &dropStore{
+		requests: make(map[string]*DropRequest),
+	}
+}
+
+// add registers a new drop request, pruning stale ones
+func (st *dropStore) add(req *DropRequest) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	st.prune()
+	st.requests[req.ID] = req
+}
+
+// prune forgets requests older than dropRequestTTL. The caller must hold
+// the mutex.
+func (st *dropStore) prune() {
+	now := time.Now()
+	for id, req := range st.requests {
+		if now.Sub(req.CreatedAt) > dropRequestTTL {
+			delete(st.requests, id)
+		}
+	}
+}
+
+// snapshot returns a copy of a drop request that is safe to encode
+func (st *dropStore) snapshot(id string) (DropRequest, bool) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	req, ok := st.requests[id]
+	if !ok {
+		return DropRequest{}, false
+	}
+	snap := *req
+	snap.Received = atomic.LoadInt64(req.progress)
+	return snap, true
+}
+
+// list returns copies of all recent drop requests, pruning stale ones
+func (st *dropStore) list() []DropRequest {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	st.prune()
+	requests := make([]DropRequest, 0, len(st.requests))
+	for _, req := range st.requests {
+		snap := *req
+		snap.Received = atomic.LoadInt64(req.progress)
+		requests = append(requests, snap)
+	}
+	return requests
+}
+
+// transition moves a request from one state to another, reporting success
+func (st *dropStore) transition(id, from, to string) (*DropRequest, bool) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	req, ok := st.requests[id]
+	if !ok || req.Status != from {
+		return nil, false
+	}
+	req.Status = to
+	return req, true
+}
+
+// finish records the outcome of an upload
+func (st *dropStore) finish(req *DropRequest, err error) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	if err != nil {
+		req.Status = dropFailed
+		req.Error = err.Error()
+		return
+	}
+	req.Status = dropCompleted
+}
+
+// handleDropPage serves the public upload page
+func (s *HTTPServer) handleDropPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	dropPage.Execute(w, map[string]interface{}{
+		"DeviceName": s.discoveryService.DeviceName(),
+		"RequirePIN": s.config.DropPIN != "",
+	})
+}
+
+// handleDropAPI dispatches the endpoints used by the public upload page
+func (s *HTTPServer) handleDropAPI(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/drop/")
+	action, id, _ := strings.Cut(rest, "/")
+
+	switch {
+	case action == "request" && id == "" && r.Method == http.MethodPost:
+		s.createDropRequest(w, r)
+	case action == "request" && id != "" && r.Method == http.MethodGet:
+		s.getDropRequest(w, r, id)
+	case action == "upload" && id != "" && r.Method == http.MethodPost:
+		s.receiveDrop(w, r, id)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// createDropRequest announces an upload and waits for the host's consent
+func (s *HTTPServer) createDropRequest(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Name  string      `json:"name"`
+		PIN   string      `json:"pin"`
+		Files []*DropFile `json:"files"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	peer, ok := s.checkPolicy(w, r, strings.TrimSpace(request.Name))
+	if !ok {
+		return
+	}
+
+	if s.config.DropPIN != "" && subtle.ConstantTimeCompare([]byte(request.PIN), []byte(s.config.DropPIN)) != 1 {
+		s.authFailed(r, "wrong drop PIN")
+		http.Error(w, "Invalid PIN", http.StatusForbidden)
+		return
+	}
+
+	if len(request.Files) == 0 {
+		http.Error(w, "No files announced", http.StatusBadRequest)
+		return
+	}
+
+	id, err := newToken()
+	if err != nil {
+		http.Error(w, "Failed to create drop request", http.StatusInternalServerError)
+		return
+	}
+
+	var totalSize int64
+	for _, file := range request.Files {
+		if file == nil || file.Size < 0 || file.Size > math.MaxInt64-totalSize {
+			http.Error(w, "Invalid file in request", http.StatusBadRequest)
+			return
+		}
+		file.Name = filepath.Base(file.Name)
+		totalSize += file.Size
+		if maxFile := megabytes(s.config.MaxFileSizeMB); maxFile > 0 && file.Size > maxFile {
+			refuseStorage(w, s.sizeError(reasonFileTooLarge))
+			return
+		}
+	}
+
+	// Refuse transfers that don't fit before asking the host
+	budget, serr := s.newBudget(peer.IP, totalSize)
+	if serr != nil {
+		refuseStorage(w, serr)
+		return
+	}
+	budget.done()
+
+	req := &DropRequest{
+		ID:        id,
+		Name:      strings.TrimSpace(request.Name),
+		IP:        senderFromRequest(r).IP,
+		Files:     request.Files,
+		TotalSize: totalSize,
+		Status:    dropPending,
+		CreatedAt: time.Now(),
+		progress:  new(int64),
+	}
+	if req.Name == "" {
+		req.Name = "Browser"
+	}
+	if !s.config.DropRequireConsent {
+		req.Status = dropAccepted
+	}
+	s.drops.add(req)
+
+	fmt.Printf("Drop request from %s (%s): %d files, %d bytes\n", req.Name, req.IP, len(req.Files), req.TotalSize)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"id":      req.ID,
+		"status":  req.Status,
+	})
+}
+
+// getDropRequest reports the state of a drop request to the visitor
+func (s *HTTPServer) getDropRequest(w http.ResponseWriter, r *http.Request, id string) {
+	req, ok := s.drops.snapshot(id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"request": req,
+	})
+}
+
+// receiveDrop streams an accepted upload into the download directory
+func (s *HTTPServer) receiveDrop(w http.ResponseWriter, r *http.Request, id string) {
+	req, ok := s.drops.transition(id, dropAccepted, dropReceiving)
+	if ok && req.IP != senderFromRequest(r).IP {
+		s.drops.finish(req, fmt.Errorf("upload came from a different address"))
+		ok = false
+	}
+	if !ok {
+		s.authFailed(r, "upload without accepted drop request")
+		http.Error(w, "Upload has not been accepted", http.StatusForbidden)
+		return
+	}
+
+	release, ok := s.acquireTransfer(w, r)
+	if !ok {
+		s.drops.finish(req, fmt.Errorf("too many concurrent transfers"))
+		return
+	}
+	defer release()
+
+	budget, serr := s.newBudget(req.IP, req.TotalSize)
+	if serr != nil {
+		s.drops.finish(req, serr)
+		refuseStorage(w, serr)
+		return
+	}
+	defer budget.done()
+
+	s.throttleBody(r)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		s.drops.finish(req, err)
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	remaining := req.TotalSize
+	result := receiveResult{Files: []string{}}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			s.drops.finish(req, err)
+			http.Error(w, "Error reading upload", http.StatusBadRequest)
+			return
+		}
+		if part.FileName() == "" {
+			continue
+		}
+
+		destPath := s.receivePath(filepath.Base(part.FileName()))
+		n, err := s.saveDropPart(part, destPath, remaining, budget, req.progress)
+		remaining -= n
+		if serr, ok := err.(*storageError); ok {
+			s.drops.finish(req, serr)
+			refuseStorage(w, serr)
+			return
+		}
+		if err != nil {
+			s.drops.finish(req, err)
+			http.Error(w, fmt.Sprintf("Failed to save %s: %v", part.FileName(), err), http.StatusBadRequest)
+			return
+		}
+
+		result.add(s.screenReceived(destPath, &InboxSender{
+			Name:       req.Name + " (browser)",
+			IP:         req.IP,
+			ReceivedAt: time.Now(),
+		}, nil))
+	}
+
+	if result.accepted() == 0 && len(result.Rejected) > 0 {
+		s.drops.finish(req, fmt.Errorf("file type not accepted"))
+		refuse(w, http.StatusForbidden, reasonFileTypeDenied, "This device does not accept files of this type")
+		return
+	}
+	s.drops.finish(req, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"message":     fmt.Sprintf("Received %d files", result.accepted()),
+		"files":       result.Files,
+		"quarantined": result.Quarantined,
+		"rejected":    result.Rejected,
+		"scanning":    result.Scanning,
+	})
+}
+
+// saveDropPart writes one uploaded file, refusing more bytes than were
+// announced or than the transfer budget allows
+func (s *HTTPServer) saveDropPart(part io.Reader, destPath string, remaining int64, budget *transferBudget, progress *int64) (int64, error) {
+	limit, tooLarge := budget.limit()
+
+	var exceeded error = fmt.Errorf("upload is larger than announced")
+	if tooLarge != nil && limit < remaining {
+		exceeded = tooLarge
+	} else {
+		limit = remaining
+	}
+
+	n, err := saveFile(part, destPath, limit, exceeded, progress)
+	if err == nil {
+		budget.spent(n)
+	}
+	return n, err
+}
+
+// handleDrops lists drop requests for the host's web interface
+func (s *HTTPServer) handleDrops(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"url":      fmt.Sprintf("http://%s:%d/drop", s.discoveryService.LocalIP(), s.port),
+		"requests": s.drops.list(),
+	})
+}
+
+// handleDropDecision accepts or rejects a pending drop request, or serves the drop page QR code
+func (s *HTTPServer) handleDropDecision(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/drops/")
+
+	if id == "qr" && r.Method == http.MethodGet {
+		code, err := qrcode.Encode(fmt.Sprintf("http://%s:%d/drop", s.discoveryService.LocalIP(), s.port))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write([]byte(code.SVG()))
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		Accept bool `json:"accept"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	status := dropRejected
+	if request.Accept {
+		status = dropAccepted
+	}
+
+	if _, ok := s.drops.transition(id, dropPending, status); !ok {
+		http.Error(w, "Drop request is not pending", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"status":  status,
+	})
+}
+
+// progressReader counts the bytes read through it
+type progressReader struct {
+	reader   io.Reader
+	progress *int64
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.reader.Read(p)
+	atomic.AddInt64(pr.progress, int64(n))
+	return n, err
+}
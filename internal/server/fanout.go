@@ -0,0 +1,336 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"localsend/internal/discovery"
+)
+
+// A file sent to several peers is read once and streamed to all of them.
+// Each peer may fall up to fanOutBuffer chunks behind the reading; slower
+// peers are dropped from the shared stream and sent the file on their own,
+// so that they don't hold back the others. Peers that fail are retried on
+// their own, up to fanOutAttempts tries in total, and dropped from the
+// shared stream if they stop reading for fanOutStallTimeout.
+const (
+	fanOutAttempts     = 3
+	fanOutBuffer       = 16
+	fanOutChunk        = 256 << 10
+	fanOutRetryDelay   = 2 * time.Second
+	fanOutStallTimeout = 30 * time.Second
+)
+
+// errStalled aborts a peer that stopped reading a fan-out
+var errStalled = errors.New("peer stopped receiving")
+
+// errLagging aborts the shared stream to a peer that fell behind the others
+var errLagging = errors.New("peer fell behind the others")
+
+// SendTarget is a peer, or a group of peers, to send files to
+type SendTarget struct {
+	TargetIP   string `json:"targetIP"`
+	TargetZone string `json:"targetZone"` // IPv6 zone for link-local targets
+	TargetPort int    `json:"targetPort"`
+	// Group names a device group, e.g. "@qa-rigs", instead of an address
+	Group string `json:"group,omitempty"`
+
+	device string // ID of the group member this target was expanded from
+}
+
+// ParseTarget parses a group ("@qa-rigs") or a device address (host,
+// host:port or [ipv6%zone]:port) as a send target
+func ParseTarget(value string, defaultPort int) (SendTarget, error) {
+	if strings.HasPrefix(value, "@") {
+		return SendTarget{Group: value}, nil
+	}
+	host, port, err := discovery.ParsePeerAddress(value, defaultPort)
+	if err != nil {
+		return SendTarget{}, err
+	}
+	target := SendTarget{TargetIP: host, TargetPort: port}
+	if i := strings.LastIndex(host, "%"); i >= 0 {
+		target.TargetIP, target.TargetZone = host[:i], host[i+1:]
+	}
+	return target, nil
+}
+
+// host returns the target's address with its zone
+func (t SendTarget) host() string {
+	if t.TargetZone != "" {
+		return t.TargetIP + "%" + t.TargetZone
+	}
+	return t.TargetIP
+}
+
+// TargetStatus is the outcome of sending files to one peer of a fan-out
+type TargetStatus struct {
+	Target  string            `json:"target"`
+	Device  string            `json:"device,omitempty"` // ID of a group member
+	Success bool              `json:"success"`
+	Mode    string            `json:"mode"`
+	Results []*TransferResult `json:"results"`
+	Errors  []string          `json:"errors"`
+
+	host string
+	port int
+	mode *transferMode
+}
+
+// newTargetStatuses negotiates the transfer mode with each target
+func (s *HTTPServer) newTargetStatuses(targets []SendTarget) []*TargetStatus {
+	statuses := make([]*TargetStatus, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target SendTarget) {
+			defer wg.Done()
+			host := target.host()
+			mode := s.negotiateTransfer(host, target.TargetPort)
+			statuses[i] = &TargetStatus{
+				Target:  net.JoinHostPort(host, strconv.Itoa(target.TargetPort)),
+				Device:  target.device,
+				Success: true,
+				Mode:    mode.Name,
+				host:    host,
+				port:    target.TargetPort,
+				mode:    mode,
+			}
+		}(i, target)
+	}
+	wg.Wait()
+	return statuses
+}
+
+// fanOut sends one file to every target, reading it only once, and returns
+// the result for each target
+func (s *HTTPServer) fanOut(statuses []*TargetStatus, filePath string) ([]*TransferResult, []error) {
+	results := make([]*TransferResult, len(statuses))
+	errs := make([]error, len(statuses))
+	for i := range statuses {
+		results[i] = &TransferResult{File: filepath.Base(filePath), Streams: 1, Attempts: 1}
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		for i := range errs {
+			errs[i] = fmt.Errorf("failed to open file: %v", err)
+		}
+		return results, errs
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		for i := range errs {
+			errs[i] = fmt.Errorf("failed to stat file: %v", err)
+		}
+		return results, errs
+	}
+	compress := shouldCompress(file, info.Size())
+
+	// Start an upload to every target, each written from its own buffer
+	streams := make([]*uploadStream, len(statuses))
+	chunks := make([]chan []byte, len(statuses))
+	var requests, writers sync.WaitGroup
+	for i, status := range statuses {
+		result := results[i]
+		result.Bytes = info.Size()
+		if compress && status.mode.Compression != "" {
+			result.Encoding = status.mode.Compression
+		}
+		raw := status.mode.supports(discovery.CapRaw)
+		result.Mode = modeMultipart
+		if raw {
+			result.Mode = modeRaw
+		}
+
+		stream, err := s.newUploadStream(status.host, status.port, result, raw)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		streams[i] = stream
+
+		requests.Add(1)
+		go func(i int) {
+			defer requests.Done()
+			errs[i] = streams[i].do(s.client)
+		}(i)
+
+		chunks[i] = make(chan []byte, fanOutBuffer)
+		writers.Add(1)
+		go func(stream *uploadStream, chunks <-chan []byte) {
+			defer writers.Done()
+			for chunk := range chunks {
+				if stream.failed() {
+					continue
+				}
+				stall := time.AfterFunc(fanOutStallTimeout, func() { stream.fail(errStalled) })
+				if _, err := stream.Write(chunk); err != nil {
+					stream.fail(err)
+				}
+				stall.Stop()
+			}
+			if !stream.failed() {
+				stream.finish(nil)
+			}
+		}(stream, chunks[i])
+	}
+
+	// Read the file once, handing each chunk to every target still receiving.
+	// A target whose buffer is full is dropped from the shared stream if
+	// another target has emptied its buffer, otherwise reading waits for it.
+	lagging := make([]bool, len(statuses))
+	behind := func(i int) bool {
+		for j, stream := range streams {
+			if j != i && stream != nil && !stream.failed() && len(chunks[j]) == 0 {
+				return true
+			}
+		}
+		return false
+	}
+	for {
+		buf := make([]byte, fanOutChunk)
+		n, rerr := file.Read(buf)
+		if n > 0 {
+			for i, stream := range streams {
+				if stream == nil || stream.failed() {
+					continue
+				}
+				select {
+				case chunks[i] <- buf[:n]:
+				default:
+					if behind(i) {
+						lagging[i] = true
+						stream.fail(errLagging)
+						continue
+					}
+					chunks[i] <- buf[:n]
+				}
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			for _, stream := range streams {
+				if stream != nil {
+					stream.fail(rerr)
+				}
+			}
+			break
+		}
+	}
+
+	for _, c := range chunks {
+		if c != nil {
+			close(c)
+		}
+	}
+	writers.Wait()
+	requests.Wait()
+
+	// Send the file to targets that fell behind on their own, and retry
+	// failed targets separately, each reading the file again
+	var retries sync.WaitGroup
+	for i, status := range statuses {
+		if errs[i] == nil {
+			results[i].measure()
+			continue
+		}
+		retries.Add(1)
+		go func(i int, status *TargetStatus) {
+			defer retries.Done()
+			if lagging[i] {
+				fmt.Printf("Sending %s to %s on its own: %v\n", results[i].File, status.Target, errLagging)
+				results[i], errs[i] = s.sendFileToDevice(status.host, status.port, filePath, status.mode)
+				if errs[i] == nil {
+					return
+				}
+			}
+			for attempt := 2; attempt <= fanOutAttempts && retryable(errs[i]); attempt++ {
+				fmt.Printf("Retrying %s to %s (attempt %d): %v\n", results[i].File, status.Target, attempt, errs[i])
+				time.Sleep(fanOutRetryDelay)
+				results[i], errs[i] = s.sendFileToDevice(status.host, status.port, filePath, status.mode)
+				results[i].Attempts = attempt
+				if errs[i] == nil {
+					return
+				}
+			}
+		}(i, status)
+	}
+	retries.Wait()
+
+	return results, errs
+}
+
+// retryable reports whether sending again may succeed, which is not the case
+// when the peer refused the transfer for a reason
+func retryable(err error) bool {
+	var refused *refusalError
+	return !errors.As(err, &refused)
+}
+
+// eachFile calls send for the files 0 to n-1, parallelFiles of them at
+// once, and waits for all of them
+func (s *HTTPServer) eachFile(n int, send func(i int)) {
+	parallel := s.config.ParallelFiles
+	if parallel < 1 {
+		parallel = 1
+	}
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			send(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// sendToTargets sends files to several peers, each file read once for all
+func (s *HTTPServer) sendToTargets(targets []SendTarget, filePaths []string) []*TargetStatus {
+	statuses := s.newTargetStatuses(targets)
+	for _, status := range statuses {
+		status.Results = make([]*TransferResult, len(filePaths))
+	}
+
+	fileErrors := make([][]error, len(filePaths))
+	s.eachFile(len(filePaths), func(f int) {
+		var results []*TransferResult
+		results, fileErrors[f] = s.fanOut(statuses, filePaths[f])
+		for i, status := range statuses {
+			status.Results[f] = results[i]
+		}
+	})
+
+	for f, filePath := range filePaths {
+		for i, status := range statuses {
+			if err := fileErrors[f][i]; err != nil {
+				status.Success = false
+				status.Errors = append(status.Errors, fmt.Sprintf("Failed to send %s: %v", filepath.Base(filePath), err))
+				status.Results[f].Error = err.Error()
+			}
+		}
+	}
+
+	for _, status := range statuses {
+		fmt.Printf("Sent %d files to %s (success: %v)\n", len(filePaths), status.Target, status.Success)
+		s.recordSent(s.targetPeer(status.host, status.port), status.Results)
+	}
+	return statuses
+}
@@ -5,6 +5,7 @@ const indexHTML = `<!DOCTYPE html>
 <head>
     <meta charset="UTF-8">
     <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <meta name="localsend-token" content="{{API_TOKEN}}">
     <title>LocalSend - File Sharing</title>
     <style>
         * {
@@ -50,6 +51,27 @@ const indexHTML = `<!DOCTYPE html>
             padding: 30px;
         }
 
+        .limit-row {
+            display: flex;
+            align-items: center;
+            gap: 15px;
+            margin: 10px 0;
+        }
+
+        .limit-row span:first-child {
+            width: 160px;
+        }
+
+        .limit-row input[type="range"] {
+            flex: 1;
+        }
+
+        .limit-row .limit-value {
+            width: 100px;
+            text-align: right;
+            color: #666;
+        }
+
         .section {
             margin-bottom: 30px;
             padding: 20px;
@@ -190,6 +212,126 @@ const indexHTML = `<!DOCTYPE html>
             border-left: 4px solid #4facfe;
         }
 
+        .inbox-item {
+            display: flex;
+            align-items: center;
+            justify-content: space-between;
+            background: #f8f9fa;
+            padding: 10px 12px;
+            margin: 8px 0;
+            border-radius: 5px;
+            border-left: 4px solid #00c9a7;
+        }
+
+        .inbox-meta {
+            color: #666;
+            font-size: 0.85em;
+        }
+
+        .text-message {
+            white-space: pre-wrap;
+            word-break: break-word;
+            font-family: monospace;
+            margin-top: 4px;
+        }
+
+        #chatTimeline {
+            max-height: 400px;
+            overflow-y: auto;
+            margin: 10px 0;
+        }
+
+        .chat-entry {
+            background: #f1f3f5;
+            padding: 8px 12px;
+            margin: 6px 0;
+            border-radius: 8px;
+            max-width: 80%;
+        }
+
+        .chat-entry.outgoing {
+            background: #dff3ff;
+            margin-left: auto;
+        }
+
+        #chatMessage {
+            width: 70%;
+            padding: 8px;
+            border: 1px solid #ddd;
+            border-radius: 5px;
+        }
+
+        #textMessage {
+            width: 100%;
+            min-height: 80px;
+            padding: 8px;
+            border: 1px solid #ddd;
+            border-radius: 5px;
+            font-family: monospace;
+            margin-bottom: 10px;
+        }
+
+        .inbox-actions a,
+        .inbox-actions button {
+            margin-left: 8px;
+            color: #4facfe;
+            background: none;
+            border: none;
+            cursor: pointer;
+            font-size: 0.95em;
+            text-decoration: none;
+        }
+
+        .share-item {
+            display: flex;
+            align-items: center;
+            background: #f8f9fa;
+            padding: 12px;
+            margin: 10px 0;
+            border-radius: 8px;
+            border-left: 4px solid #764ba2;
+        }
+
+        .share-item img {
+            width: 120px;
+            height: 120px;
+            margin-right: 15px;
+        }
+
+        .share-item a {
+            color: #4facfe;
+            word-break: break-all;
+        }
+
+        .share-options input {
+            width: 80px;
+            padding: 6px;
+            margin: 0 10px 10px 5px;
+            border: 1px solid #ccc;
+            border-radius: 5px;
+        }
+
+        .drop-item {
+            background: #fff8e1;
+            padding: 12px;
+            margin: 10px 0;
+            border-radius: 8px;
+            border-left: 4px solid #ffb300;
+        }
+
+        .progress {
+            height: 8px;
+            background: #e9ecef;
+            border-radius: 4px;
+            overflow: hidden;
+            margin-top: 8px;
+        }
+
+        .progress-bar {
+            height: 100%;
+            background: linear-gradient(135deg, #4facfe 0%, #00f2fe 100%);
+        }
+
         .loading {
             display: inline-block;
             width: 20px;
@@ -222,7 +364,24 @@ const indexHTML = `<!DOCTYPE html>
                 <button class="btn" onclick="discoverDevices()" id="discoverBtn">
                     Cari Perangkat
                 </button>
+                <label style="margin-left: 10px;"><input type="checkbox" id="sweepToggle"> Pindai seluruh subnet</label>
+                <div class="share-options">
+                    <label>Tambah manual<input type="text" id="peerAddress" placeholder="10.8.0.5:8080" style="width: 200px;"></label>
+                    <button class="btn" onclick="addPeer()">Tambah</button>
+                </div>
                 <div class="devices-list" id="devicesList"></div>
+                <p class="device-ip" style="margin-top: 10px;">Sidik jari perangkat ini: <code id="ownFingerprint">-</code></p>
+            </div>
+
+            <!-- Device Groups Section -->
+            <div class="section">
+                <h2>👥 Grup Perangkat</h2>
+                <p>Simpan perangkat yang dipilih sebagai grup untuk mengirim ke semuanya sekaligus. Anggota dikenali dari ID perangkat, jadi tetap berlaku walau alamat IP berubah.</p>
+                <div class="share-options">
+                    <label>Nama grup<input type="text" id="groupName" placeholder="QA rigs" style="width: 200px;"></label>
+                    <button class="btn" onclick="saveGroup()">Simpan Pilihan sebagai Grup</button>
+                </div>
+                <div id="groupList"></div>
             </div>
 
             <!-- File Selection Section -->
@@ -247,13 +406,123 @@ const indexHTML = `<!DOCTYPE html>
                 </button>
             </div>
 
+            <!-- Text Section -->
+            <div class="section">
+                <h2>💬 Pesan Teks</h2>
+                <p>Kirim URL, perintah atau isi clipboard ke perangkat yang dipilih</p>
+                <textarea id="textMessage" placeholder="https://..."></textarea>
+                <button class="btn" onclick="pasteText()">📋 Tempel dari Clipboard</button>
+                <button class="btn" onclick="sendText()" id="sendTextBtn">Kirim Teks</button>
+                <div id="textList"></div>
+            </div>
+
+            <!-- Conversation Section -->
+            <div class="section">
+                <h2>🗨️ Percakapan</h2>
+                <p>Riwayat pesan dan file dengan setiap perangkat, disimpan di perangkat ini</p>
+                <div id="chatList"></div>
+                <div id="chatView" style="display: none;">
+                    <h3 id="chatTitle"></h3>
+                    <div id="chatTimeline"></div>
+                    <input type="text" id="chatMessage" placeholder="Tulis pesan..." onkeydown="if (event.key === 'Enter') sendChatMessage(this.value)">
+                    <button class="btn" onclick="sendChatMessage(document.getElementById('chatMessage').value)">Kirim</button>
+                    <button class="btn" onclick="clearChat()">Hapus Riwayat</button>
+                </div>
+            </div>
+
+            <!-- Share Link Section -->
+            <div class="section">
+                <h2>🔗 Bagikan via Tautan</h2>
+                <p>Buat tautan untuk perangkat tanpa aplikasi (misalnya ponsel) agar bisa mengunduh file yang dipilih</p>
+                <div class="share-options">
+                    <label>Berlaku (menit)<input type="number" id="shareExpiry" value="60" min="1"></label>
+                    <label>Maks. unduhan<input type="number" id="shareMaxDownloads" value="0" min="0"></label>
+                </div>
+                <button class="btn" onclick="createShare()" id="shareBtn">Buat Tautan</button>
+                <div id="shareList"></div>
+            </div>
+
+            <!-- Browser Drop Section -->
+            <div class="section">
+                <h2>📲 Terima dari Browser</h2>
+                <p>Perangkat tanpa aplikasi dapat mengirim file ke sini lewat <a id="dropUrl" target="_blank"></a></p>
+                <div class="share-item"><img id="dropQr" alt="QR"><div id="dropRequests"></div></div>
+            </div>
+
+            <!-- Inbox Section -->
+            <div class="section">
+                <h2>📥 Kotak Masuk</h2>
+                <p>File yang sudah diterima perangkat ini</p>
+                <button class="btn" onclick="loadInbox()">Muat Ulang</button>
+                <div id="inboxList"></div>
+            </div>
+
+            <!-- Quarantine Section -->
+            <div class="section">
+                <h2>🧪 Karantina</h2>
+                <p>File yang ditahan oleh aturan tipe file atau pemindai. File hanya masuk ke folder download setelah dilepaskan.</p>
+                <button class="btn" onclick="loadQuarantine()">Muat Ulang</button>
+                <div id="quarantineList"></div>
+            </div>
+
+            <!-- Device Policy Section -->
+            <div class="section">
+                <h2>🛡️ Kebijakan Perangkat</h2>
+                <p>Perangkat yang diblokir tidak terlihat dan tidak bisa mengirim. Jika daftar izin diisi, hanya perangkat yang cocok yang diterima.</p>
+                <div class="share-options">
+                    <label>Daftar
+                        <select id="policyList">
+                            <option value="block">Blokir</option>
+                            <option value="allow">Izinkan</option>
+                        </select>
+                    </label>
+                    <label>Berdasarkan
+                        <select id="policyField">
+                            <option value="id">ID perangkat</option>
+                            <option value="fingerprint">Sidik jari</option>
+                            <option value="ip">IP / CIDR</option>
+                            <option value="name">Pola nama</option>
+                        </select>
+                    </label>
+                    <label>Nilai<input type="text" id="policyValue" placeholder="build-*" style="width: 200px;"></label>
+                    <button class="btn" onclick="addPolicyRule()">Tambah</button>
+                </div>
+                <div id="policyRules"></div>
+            </div>
+
+            <!-- Bandwidth Section -->
+            <div class="section">
+                <h2>📶 Batas Bandwidth</h2>
+                <p>Batasi kecepatan transfer agar jaringan tetap bisa dipakai untuk hal lain. Perubahan langsung berlaku untuk transfer baru dan transfer yang sedang diterima; pengiriman file yang sudah berjalan tanpa batas dapat tetap berjalan tanpa batas hingga selesai.</p>
+                <div id="bandwidthLimits">
+                    <div class="limit-row"><span>Kirim (total)</span><input type="range" min="0" max="102400" step="512" data-limit="upload"><span class="limit-value"></span></div>
+                    <div class="limit-row"><span>Terima (total)</span><input type="range" min="0" max="102400" step="512" data-limit="download"><span class="limit-value"></span></div>
+                    <div class="limit-row"><span>Kirim per perangkat</span><input type="range" min="0" max="102400" step="512" data-limit="peerUpload"><span class="limit-value"></span></div>
+                    <div class="limit-row"><span>Terima per perangkat</span><input type="range" min="0" max="102400" step="512" data-limit="peerDownload"><span class="limit-value"></span></div>
+                </div>
+            </div>
+
             <!-- Status Section -->
             <div class="status" id="status"></div>
         </div>
     </div>
 
     <script>
-        let selectedDevice = null;
+        const apiToken = document.querySelector('meta[name="localsend-token"]').content;
+
+        // api calls the control API with the token it requires
+        function api(url, options) {
+            options = options || {};
+            options.headers = Object.assign({}, options.headers, { 'X-LocalSend-Token': apiToken });
+            return fetch(url, options);
+        }
+
+        // withToken adds the token to URLs used in links and images
+        function withToken(url) {
+            return url + (url.includes('?') ? '&' : '?') + 'token=' + encodeURIComponent(apiToken);
+        }
+
+        let selectedDevices = [];
         let selectedFiles = [];
         let discoveredDevices = [];
 
@@ -321,7 +590,8 @@ const indexHTML = `<!DOCTYPE html>
             showStatus('Mencari perangkat di jaringan...', 'info');
             
             try {
-                const response = await fetch('/api/discover', {
+                const sweep = document.getElementById('sweepToggle').checked;
+                const response = await api('/api/discover' + (sweep ? '?sweep=1' : ''), {
                     method: 'POST'
                 });
                 
@@ -330,6 +600,7 @@ const indexHTML = `<!DOCTYPE html>
                 if (data.success) {
                     discoveredDevices = data.devices || [];
                     displayDevices();
+                    loadGroups();
                     showStatus('Ditemukan ' + discoveredDevices.length + ' perangkat', 'success');
                 } else {
                     showStatus('Gagal mencari perangkat', 'error');
@@ -345,6 +616,8 @@ const indexHTML = `<!DOCTYPE html>
         function displayDevices() {
             const devicesList = document.getElementById('devicesList');
             devicesList.innerHTML = '';
+            selectedDevices = selectedDevices.filter(d => discoveredDevices.some(device => formatAddress(device) === formatAddress(d)));
+            updateSendButton();
             
             if (discoveredDevices.length === 0) {
                 devicesList.innerHTML = '<p style="color: #666; text-align: center; padding: 20px;">Tidak ada perangkat ditemukan</p>';
@@ -354,35 +627,214 @@ const indexHTML = `<!DOCTYPE html>
             discoveredDevices.forEach((device, index) => {
                 const deviceElement = document.createElement('div');
                 deviceElement.className = 'device';
+                if (selectedDevices.some(d => formatAddress(d) === formatAddress(device))) {
+                    deviceElement.classList.add('selected');
+                }
                 deviceElement.onclick = () => selectDevice(index);
-                deviceElement.innerHTML = '<div class="device-name">' + device.name + '</div>' +
-                    '<div class="device-ip">' + device.ip + ':' + device.port + '</div>';
+                deviceElement.innerHTML = '<div class="device-name">' + (device.pinned ? '📌 ' : '') + deviceIcon(device) + ' ' + escapeHTML(device.name) + '</div>' +
+                    '<div class="device-ip">' + escapeHTML(formatAddress(device)) + '</div>' +
+                    '<div class="device-ip">' + escapeHTML(describeDevice(device)) + '</div>' +
+                    '<div class="device-ip">' + trustBadge(device) + '</div>';
+                if (device.nameConflict) {
+                    deviceElement.style.borderColor = '#e53935';
+                }
+                const blockBtn = document.createElement('button');
+                blockBtn.textContent = 'Blokir';
+                blockBtn.style.cssText = 'float: right; margin-left: 8px; background: none; border: none; color: #e53935; cursor: pointer;';
+                blockBtn.onclick = (e) => {
+                    e.stopPropagation();
+                    blockDevice(device.name, device);
+                };
+                deviceElement.prepend(blockBtn);
+                if (device.fingerprint && !device.verified) {
+                    const trustBtn = document.createElement('button');
+                    trustBtn.textContent = 'Percayai';
+                    trustBtn.style.cssText = 'float: right; margin-left: 8px; background: none; border: none; color: #4facfe; cursor: pointer;';
+                    trustBtn.onclick = (e) => {
+                        e.stopPropagation();
+                        trustDevice(device);
+                    };
+                    deviceElement.prepend(trustBtn);
+                }
+                if (device.pinned) {
+                    const removeBtn = document.createElement('button');
+                    removeBtn.textContent = 'Hapus';
+                    removeBtn.className = 'inbox-actions';
+                    removeBtn.style.cssText = 'float: right; background: none; border: none; color: #4facfe; cursor: pointer;';
+                    removeBtn.onclick = (e) => {
+                        e.stopPropagation();
+                        removePeer(device.address);
+                    };
+                    deviceElement.prepend(removeBtn);
+                }
                 devicesList.appendChild(deviceElement);
             });
         }
 
+        async function addPeer() {
+            const input = document.getElementById('peerAddress');
+            const address = input.value.trim();
+            if (!address) {
+                return;
+            }
+
+            try {
+                const response = await api('/api/peers', {
+                    method: 'POST',
+                    headers: {
+                        'Content-Type': 'application/json'
+                    },
+                    body: JSON.stringify({ address: address })
+                });
+
+                if (!response.ok) {
+                    throw new Error(await response.text());
+                }
+
+                input.value = '';
+                await refreshPeers();
+                showStatus('Perangkat ditambahkan', 'success');
+            } catch (error) {
+                showStatus('Gagal menambah perangkat: ' + error.message, 'error');
+            }
+        }
+
+        async function removePeer(address) {
+            await api('/api/peers', {
+                method: 'DELETE',
+                headers: {
+                    'Content-Type': 'application/json'
+                },
+                body: JSON.stringify({ address: address })
+            });
+            selectedDevices = selectedDevices.filter(d => d.address !== address);
+            updateSendButton();
+            await refreshPeers();
+        }
+
+        async function refreshPeers() {
+            const response = await api('/api/peers');
+            const data = await response.json();
+            discoveredDevices = data.peers || [];
+            displayDevices();
+        }
+
+        function formatFingerprint(fingerprint) {
+            return fingerprint.slice(0, 16).match(/.{4}/g).join(' ');
+        }
+
+        function trustBadge(device) {
+            if (device.nameConflict) {
+                return '<span style="color: #e53935;">⚠️ Nama sama dengan perangkat lain, kunci tidak terverifikasi</span>';
+            }
+            if (device.verified) {
+                return '<span style="color: #2e7d32;">✅ Terverifikasi</span>';
+            }
+            if (device.fingerprint) {
+                return 'Belum dipasangkan · ' + escapeHTML(formatFingerprint(device.fingerprint));
+            }
+            return 'Tidak ditandatangani';
+        }
+
+        async function trustDevice(device) {
+            const message = 'Percayai "' + device.name + '"?\n\nPastikan sidik jari ini sama dengan yang tampil di perangkat tersebut:\n' + device.fingerprint;
+            if (!confirm(message)) {
+                return;
+            }
+            await api('/api/trust', {
+                method: 'POST',
+                headers: {
+                    'Content-Type': 'application/json'
+                },
+                body: JSON.stringify({ name: device.name, fingerprint: device.fingerprint })
+            });
+            await refreshPeers();
+        }
+
+        async function loadIdentity() {
+            const response = await api('/api/trust');
+            const data = await response.json();
+            document.getElementById('ownFingerprint').textContent = formatFingerprint(data.fingerprint);
+            document.getElementById('ownFingerprint').title = data.fingerprint;
+        }
+
+        function deviceIcon(device) {
+            switch (device.deviceType) {
+                case 'server': return '🗄️';
+                case 'mobile': return '📱';
+                default: return '💻';
+            }
+        }
+
+        function describeDevice(device) {
+            if (!device.version) {
+                return 'Protokol v1';
+            }
+            const parts = [];
+            if (device.os) parts.push(device.os);
+            if (device.appVersion) parts.push('v' + device.appVersion);
+            parts.push('protokol v' + device.version);
+            return parts.join(' · ');
+        }
+
+        function formatAddress(device) {
+            const host = device.zone ? device.ip + '%' + device.zone : device.ip;
+            return (host.includes(':') ? '[' + host + ']' : host) + ':' + device.port;
+        }
+
         function selectDevice(index) {
-            // Remove previous selection
-            document.querySelectorAll('.device').forEach(d => d.classList.remove('selected'));
-            
-            // Select new device
-            document.querySelectorAll('.device')[index].classList.add('selected');
-            selectedDevice = discoveredDevices[index];
+            // Clicking a device adds it to or removes it from the targets
+            const device = discoveredDevices[index];
+            const element = document.querySelectorAll('.device')[index];
+            const position = selectedDevices.findIndex(d => formatAddress(d) === formatAddress(device));
+            if (position >= 0) {
+                selectedDevices.splice(position, 1);
+                element.classList.remove('selected');
+            } else {
+                selectedDevices.push(device);
+                element.classList.add('selected');
+            }
             
             updateSendButton();
-            showStatus('Perangkat dipilih: ' + selectedDevice.name, 'info');
+            if (selectedDevices.length > 0) {
+                showStatus('Perangkat dipilih: ' + selectedDevices.map(d => escapeHTML(d.name)).join(', '), 'info');
+            }
         }
 
         function updateSendButton() {
             const sendBtn = document.getElementById('sendBtn');
-            sendBtn.disabled = !selectedDevice || selectedFiles.length === 0;
+            sendBtn.disabled = selectedDevices.length === 0 || selectedFiles.length === 0;
+        }
+
+        function sendTarget(device) {
+            return {
+                targetIP: device.ip,
+                targetZone: device.zone || '',
+                targetPort: device.port
+            };
+        }
+
+        function compressionNote(results) {
+            const compressed = (results || []).filter(r => r.encoding);
+            if (compressed.length === 0) {
+                return '';
+            }
+            const bytes = compressed.reduce((sum, r) => sum + r.bytes, 0);
+            const wire = compressed.reduce((sum, r) => sum + r.wireBytes, 0);
+            return ' (dikompresi, rasio ' + (bytes / wire).toFixed(2) + 'x)';
         }
 
         async function sendFiles() {
-            if (!selectedDevice || selectedFiles.length === 0) {
+            if (selectedDevices.length === 0 || selectedFiles.length === 0) {
                 showStatus('Pilih perangkat dan file terlebih dahulu', 'error');
                 return;
             }
+
+            for (const device of selectedDevices) {
+                if (device.nameConflict && !confirm('Perangkat "' + device.name + '" memakai nama perangkat lain dan kuncinya tidak terverifikasi. Tetap kirim?')) {
+                    return;
+                }
+            }
             
             const btn = document.getElementById('sendBtn');
             const originalText = btn.innerHTML;
@@ -394,45 +846,37 @@ const indexHTML = `<!DOCTYPE html>
             
             try {
                 // First upload files to our server
-                const formData = new FormData();
-                selectedFiles.forEach(file => {
-                    formData.append('files', file);
-                });
-                
-                const uploadResponse = await fetch('/api/upload', {
-                    method: 'POST',
-                    body: formData
-                });
-                
-                const uploadData = await uploadResponse.json();
+                const uploadedFiles = await uploadSelectedFiles();
                 
-                if (!uploadData.success) {
-                    throw new Error('Gagal mengupload file');
-                }
-                
-                // Then send files to target device
-                const sendResponse = await fetch('/api/send', {
+                // Then send files to the target devices, streaming each file to all of them at once
+                const filePaths = uploadedFiles.map(f => f.path);
+                const request = selectedDevices.length === 1 ?
+                    Object.assign(sendTarget(selectedDevices[0]), { filePaths: filePaths }) :
+                    { targets: selectedDevices.map(sendTarget), filePaths: filePaths };
+                const sendResponse = await api('/api/send', {
                     method: 'POST',
                     headers: {
                         'Content-Type': 'application/json'
                     },
-                    body: JSON.stringify({
-                        targetIP: selectedDevice.ip,
-                        targetPort: selectedDevice.port,
-                        filePaths: uploadData.files.map(f => f.path)
-                    })
+                    body: JSON.stringify(request)
                 });
                 
                 const sendData = await sendResponse.json();
                 
                 if (sendData.success) {
-                    showStatus('File berhasil dikirim ke ' + selectedDevice.name + '!', 'success');
+                    const names = selectedDevices.map(d => escapeHTML(d.name)).join(', ');
+                    const results = sendData.targets ? sendData.targets.flatMap(t => t.results) : sendData.results;
+                    showStatus('File berhasil dikirim ke ' + names + '!' + compressionNote(results), 'success');
                     // Clear selections
                     selectedFiles = [];
                     document.getElementById('fileInput').value = '';
                     displaySelectedFiles();
+                } else if (sendData.targets) {
+                    const lines = sendData.targets.map(t => (t.success ? '✅ ' : '❌ ') + escapeHTML(t.target) +
+                        (t.success ? '' : ': ' + escapeHTML((t.errors || []).join(', '))));
+                    showStatus('Sebagian pengiriman gagal:<br>' + lines.join('<br>'), 'error');
                 } else {
-                    showStatus('Gagal mengirim file: ' + (sendData.errors || []).join(', '), 'error');
+                    showStatus('Gagal mengirim file: ' + escapeHTML((sendData.errors || []).join(', ')), 'error');
                 }
             } catch (error) {
                 showStatus('Error: ' + error.message, 'error');
@@ -442,24 +886,1041 @@ const indexHTML = `<!DOCTYPE html>
             }
         }
 
-        function showStatus(message, type) {
-            const status = document.getElementById('status');
-            status.className = 'status ' + type;
-            status.innerHTML = message;
-            status.style.display = 'block';
-            
-            // Auto hide after 5 seconds for success messages
-            if (type === 'success') {
-                setTimeout(() => {
-                    status.style.display = 'none';
-                }, 5000);
+        async function uploadSelectedFiles() {
+            const formData = new FormData();
+            selectedFiles.forEach(file => {
+                formData.append('files', file);
+            });
+
+            const uploadResponse = await api('/api/upload', {
+                method: 'POST',
+                body: formData
+            });
+
+            const uploadData = await uploadResponse.json();
+
+            if (!uploadData.success) {
+                throw new Error('Gagal mengupload file');
             }
+
+            return uploadData.files;
         }
 
-        // Auto-discover devices on page load
-        window.addEventListener('load', function() {
-            setTimeout(discoverDevices, 1000);
-        });
+        async function createShare() {
+            if (selectedFiles.length === 0) {
+                showStatus('Pilih file terlebih dahulu', 'error');
+                return;
+            }
+
+            const btn = document.getElementById('shareBtn');
+            btn.disabled = true;
+
+            try {
+                const files = await uploadSelectedFiles();
+                const response = await api('/api/shares', {
+                    method: 'POST',
+                    headers: {
+                        'Content-Type': 'application/json'
+                    },
+                    body: JSON.stringify({
+                        filePaths: files.map(f => f.path),
+                        expiresIn: parseInt(document.getElementById('shareExpiry').value) || 0,
+                        maxDownloads: parseInt(document.getElementById('shareMaxDownloads').value) || 0
+                    })
+                });
+
+                if (!response.ok) {
+                    throw new Error(await response.text());
+                }
+
+                showStatus('Tautan berhasil dibuat', 'success');
+                loadShares();
+            } catch (error) {
+                showStatus('Gagal membuat tautan: ' + error.message, 'error');
+            } finally {
+                btn.disabled = false;
+            }
+        }
+
+        async function loadShares() {
+            const shareList = document.getElementById('shareList');
+
+            try {
+                const response = await api('/api/shares');
+                const data = await response.json();
+
+                shareList.innerHTML = '';
+                (data.shares || []).forEach(share => {
+                    const limit = share.maxDownloads > 0 ? share.downloads + '/' + share.maxDownloads : share.downloads;
+                    const item = document.createElement('div');
+                    item.className = 'share-item';
+                    item.innerHTML = '<img src="' + withToken('/api/shares/' + share.token + '/qr') + '" alt="QR">' +
+                        '<div><a href="' + share.url + '" target="_blank">' + escapeHTML(share.url) + '</a>' +
+                        '<div class="inbox-meta">' + share.files.length + ' file • diunduh ' + limit +
+                        ' • berlaku hingga ' + new Date(share.expiresAt).toLocaleTimeString() + '</div>' +
+                        '<div class="inbox-actions"><button onclick="revokeShare(\'' + share.token + '\')">Cabut</button></div></div>';
+                    shareList.appendChild(item);
+                });
+            } catch (error) {
+                showStatus('Gagal memuat tautan: ' + error.message, 'error');
+            }
+        }
+
+        async function revokeShare(token) {
+            await api('/api/shares/' + token, { method: 'DELETE' });
+            loadShares();
+        }
+
+        async function loadDrops() {
+            try {
+                const response = await api('/api/drops');
+                const data = await response.json();
+
+                const dropUrl = document.getElementById('dropUrl');
+                if (dropUrl.textContent !== data.url) {
+                    dropUrl.textContent = data.url;
+                    dropUrl.href = data.url;
+                    document.getElementById('dropQr').src = withToken('/api/drops/qr');
+                }
+
+                const dropRequests = document.getElementById('dropRequests');
+                dropRequests.innerHTML = '';
+                let finished = false;
+                (data.requests || []).forEach(req => {
+                    const item = document.createElement('div');
+                    item.className = 'drop-item';
+                    let html = '<strong>' + escapeHTML(req.name) + '</strong> (' + escapeHTML(req.ip) + ') ingin mengirim ' +
+                        req.files.length + ' file, ' + formatFileSize(req.totalSize);
+                    if (req.status === 'pending') {
+                        html += '<div class="inbox-actions">' +
+                            '<button onclick="decideDrop(\'' + req.id + '\', true)">Terima</button>' +
+                            '<button onclick="decideDrop(\'' + req.id + '\', false)">Tolak</button></div>';
+                    } else if (req.status === 'receiving') {
+                        const percent = req.totalSize > 0 ? Math.round(req.received / req.totalSize * 100) : 100;
+                        html += '<div class="progress"><div class="progress-bar" style="width: ' + percent + '%"></div></div>';
+                    } else {
+                        html += '<div class="inbox-meta">' + escapeHTML(req.status) + (req.error ? ': ' + escapeHTML(req.error) : '') + '</div>';
+                        finished = finished || req.status === 'completed';
+                    }
+                    item.innerHTML = html;
+                    dropRequests.appendChild(item);
+                });
+                if (finished) {
+                    loadInbox();
+                }
+            } catch (error) {
+                console.error('Failed to load drop requests', error);
+            }
+        }
+
+        async function decideDrop(id, accept) {
+            await api('/api/drops/' + id, {
+                method: 'POST',
+                headers: {
+                    'Content-Type': 'application/json'
+                },
+                body: JSON.stringify({ accept: accept })
+            });
+            loadDrops();
+        }
+
+        function escapeHTML(text) {
+            const div = document.createElement('div');
+            div.textContent = text;
+            return div.innerHTML;
+        }
+
+        async function loadInbox() {
+            const inboxList = document.getElementById('inboxList');
+
+            try {
+                const response = await api('/api/received');
+                const data = await response.json();
+                const files = data.files || [];
+
+                inboxList.innerHTML = '';
+                if (files.length === 0) {
+                    inboxList.innerHTML = '<p style="color: #666; text-align: center; padding: 20px;">Belum ada file diterima</p>';
+                    return;
+                }
+
+                files.forEach(file => {
+                    const sender = file.sender ? (file.sender.name || file.sender.ip) : 'tidak diketahui';
+                    const item = document.createElement('div');
+                    item.className = 'inbox-item';
+                    item.innerHTML = '<div><strong>' + escapeHTML(file.name) + '</strong>' +
+                        '<div class="inbox-meta">' + formatFileSize(file.size) + ' • dari ' + escapeHTML(sender) +
+                        ' • ' + new Date(file.modTime).toLocaleString() + '</div></div>' +
+                        '<div class="inbox-actions">' +
+                        '<a href="' + withToken('/api/received/' + file.id) + '">Unduh</a>' +
+                        '<button onclick="deleteReceived(\'' + file.id + '\')">Hapus</button></div>';
+                    if (file.sender && file.sender.ip) {
+                        const blockBtn = document.createElement('button');
+                        blockBtn.textContent = 'Blokir pengirim';
+                        blockBtn.onclick = () => blockDevice(sender, file.sender);
+                        item.querySelector('.inbox-actions').appendChild(blockBtn);
+                    }
+                    inboxList.appendChild(item);
+                });
+            } catch (error) {
+                showStatus('Gagal memuat kotak masuk: ' + error.message, 'error');
+            }
+        }
+
+        async function loadQuarantine() {
+            const list = document.getElementById('quarantineList');
+
+            try {
+                const response = await api('/api/quarantine');
+                const data = await response.json();
+                const files = data.files || [];
+
+                list.innerHTML = '';
+                if (files.length === 0) {
+                    list.innerHTML = '<p style="color: #666; text-align: center; padding: 20px;">Tidak ada file di karantina</p>';
+                    return;
+                }
+
+                files.forEach(file => {
+                    const sender = file.sender ? (file.sender.name || file.sender.ip) : 'tidak diketahui';
+                    const item = document.createElement('div');
+                    item.className = 'inbox-item';
+                    item.innerHTML = '<div><strong>' + escapeHTML(file.name) + '</strong>' +
+                        '<div class="inbox-meta">' + formatFileSize(file.size) + ' • dari ' + escapeHTML(sender) +
+                        ' • ' + escapeHTML(file.reason) + '</div></div>' +
+                        '<div class="inbox-actions">' +
+                        '<button onclick="releaseQuarantined(\'' + file.id + '\')">Lepaskan</button>' +
+                        '<button onclick="deleteQuarantined(\'' + file.id + '\')">Hapus</button></div>';
+                    list.appendChild(item);
+                });
+            } catch (error) {
+                showStatus('Gagal memuat karantina: ' + error.message, 'error');
+            }
+        }
+
+        async function releaseQuarantined(id) {
+            if (!confirm('Lepaskan file ini ke folder download? Pastikan file ini aman.')) {
+                return;
+            }
+            await api('/api/quarantine/' + id + '/release', { method: 'POST' });
+            loadQuarantine();
+            loadInbox();
+        }
+
+        async function deleteQuarantined(id) {
+            if (!confirm('Hapus file ini?')) {
+                return;
+            }
+            await api('/api/quarantine/' + id, { method: 'DELETE' });
+            loadQuarantine();
+        }
+
+        async function blockDevice(name, source) {
+            const rule = source.fingerprint ? { fingerprint: source.fingerprint } : { ip: source.ip };
+            if (!confirm('Blokir "' + name + '"? Perangkat ini tidak akan terlihat dan tidak bisa mengirim file.')) {
+                return;
+            }
+            await savePolicyRule('POST', 'block', rule);
+            await refreshPeers();
+        }
+
+        async function addPolicyRule() {
+            const value = document.getElementById('policyValue').value.trim();
+            if (!value) {
+                return;
+            }
+            const rule = {};
+            rule[document.getElementById('policyField').value] = value;
+            await savePolicyRule('POST', document.getElementById('policyList').value, rule);
+            document.getElementById('policyValue').value = '';
+        }
+
+        async function savePolicyRule(method, list, rule) {
+            const response = await api('/api/policy', {
+                method: method,
+                headers: {
+                    'Content-Type': 'application/json'
+                },
+                body: JSON.stringify({ list: list, rule: rule })
+            });
+            if (!response.ok) {
+                showStatus('Gagal menyimpan kebijakan: ' + await response.text(), 'error');
+                return;
+            }
+            displayPolicy(await response.json());
+        }
+
+        async function loadPolicy() {
+            const response = await api('/api/policy');
+            displayPolicy(await response.json());
+        }
+
+        function displayPolicy(data) {
+            const container = document.getElementById('policyRules');
+            container.innerHTML = '';
+            const rules = [];
+            (data.block || []).forEach(rule => rules.push({ list: 'block', rule: rule }));
+            (data.allow || []).forEach(rule => rules.push({ list: 'allow', rule: rule }));
+
+            if (rules.length === 0) {
+                container.innerHTML = '<p style="color: #666; text-align: center; padding: 20px;">Belum ada aturan</p>';
+                return;
+            }
+
+            rules.forEach(entry => {
+                const item = document.createElement('div');
+                item.className = 'inbox-item';
+                const parts = Object.keys(entry.rule).map(key => key + ': ' + entry.rule[key]);
+                item.innerHTML = '<div><strong>' + (entry.list === 'block' ? '⛔ Blokir' : '✅ Izinkan') + '</strong>' +
+                    '<div class="inbox-meta">' + escapeHTML(parts.join(', ')) + '</div></div>' +
+                    '<div class="inbox-actions"></div>';
+                const removeBtn = document.createElement('button');
+                removeBtn.textContent = 'Hapus';
+                removeBtn.onclick = () => savePolicyRule('DELETE', entry.list, entry.rule).then(refreshPeers);
+                item.querySelector('.inbox-actions').appendChild(removeBtn);
+                container.appendChild(item);
+            });
+        }
+
+        async function pasteText() {
+            try {
+                document.getElementById('textMessage').value = await navigator.clipboard.readText();
+            } catch (error) {
+                showStatus('Clipboard tidak bisa dibaca: ' + error.message, 'error');
+            }
+        }
+
+        async function sendText() {
+            const text = document.getElementById('textMessage').value;
+            if (selectedDevices.length === 0 || !text.trim()) {
+                showStatus('Pilih perangkat dan tulis teks terlebih dahulu', 'error');
+                return;
+            }
+
+            const failed = [];
+            await Promise.all(selectedDevices.map(async device => {
+                const response = await api('/api/send-text', {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify(Object.assign(sendTarget(device), { text: text }))
+                });
+                const data = response.ok ? await response.json() : { error: await response.text() };
+                if (!data.success) {
+                    failed.push(escapeHTML(device.name) + ': ' + escapeHTML(data.error));
+                }
+            }));
+
+            if (failed.length > 0) {
+                showStatus('Gagal mengirim teks:<br>' + failed.join('<br>'), 'error');
+                return;
+            }
+            document.getElementById('textMessage').value = '';
+            showStatus('Teks terkirim ke ' + selectedDevices.map(d => escapeHTML(d.name)).join(', '), 'success');
+        }
+
+        function linkify(element, text) {
+            // Show the text with its http(s) URLs as links that open in a new tab
+            const pattern = /https?:\/\/[^\s<>"']+/g;
+            let last = 0;
+            for (const match of text.matchAll(pattern)) {
+                element.appendChild(document.createTextNode(text.slice(last, match.index)));
+                const link = document.createElement('a');
+                link.href = match[0];
+                link.textContent = match[0];
+                link.target = '_blank';
+                link.rel = 'noopener noreferrer';
+                element.appendChild(link);
+                last = match.index + match[0].length;
+            }
+            element.appendChild(document.createTextNode(text.slice(last)));
+        }
+
+        async function loadTexts() {
+            try {
+                const response = await api('/api/texts');
+                displayTexts((await response.json()).texts);
+            } catch (error) {
+                console.error('Failed to load texts', error);
+            }
+        }
+
+        function displayTexts(texts) {
+            const container = document.getElementById('textList');
+            container.innerHTML = '';
+
+            texts.forEach(msg => {
+                const item = document.createElement('div');
+                item.className = 'inbox-item';
+                item.innerHTML = '<div><div class="inbox-meta">' + escapeHTML(msg.sender.name || msg.sender.ip) + ' • ' +
+                    new Date(msg.sender.receivedAt).toLocaleString() + '</div><div class="text-message"></div></div>' +
+                    '<div class="inbox-actions"></div>';
+                linkify(item.querySelector('.text-message'), msg.text);
+
+                const copyBtn = document.createElement('button');
+                copyBtn.textContent = 'Salin';
+                copyBtn.onclick = async () => {
+                    await navigator.clipboard.writeText(msg.text);
+                    showStatus('Teks disalin ke clipboard', 'success');
+                };
+                const removeBtn = document.createElement('button');
+                removeBtn.textContent = 'Hapus';
+                removeBtn.onclick = async () => {
+                    await api('/api/texts/' + msg.id, { method: 'DELETE' });
+                    loadTexts();
+                };
+                item.querySelector('.inbox-actions').appendChild(copyBtn);
+                item.querySelector('.inbox-actions').appendChild(removeBtn);
+                container.appendChild(item);
+            });
+        }
+
+        let currentChat = null;
+
+        const chatStatusLabels = {
+            delivered: '✓ terkirim',
+            failed: '✗ gagal',
+            received: 'diterima',
+            quarantined: 'dikarantina',
+            rejected: 'ditolak',
+            scanning: 'sedang dipindai'
+        };
+
+        function chatPreview(entry) {
+            const text = entry.kind === 'file' ? '📄 ' + entry.file : entry.text;
+            return (entry.outgoing ? 'Anda: ' : '') + (text.length > 60 ? text.slice(0, 60) + '…' : text);
+        }
+
+        async function loadChats() {
+            try {
+                const response = await api('/api/chats');
+                displayChats((await response.json()).chats);
+                if (currentChat) {
+                    showChat(currentChat.peer);
+                }
+            } catch (error) {
+                console.error('Failed to load conversations', error);
+            }
+        }
+
+        function displayChats(chats) {
+            const container = document.getElementById('chatList');
+            container.innerHTML = '';
+
+            if (chats.length === 0) {
+                container.innerHTML = '<p style="color: #666; text-align: center; padding: 20px;">Belum ada percakapan</p>';
+                return;
+            }
+
+            chats.forEach(chat => {
+                const item = document.createElement('div');
+                item.className = 'inbox-item';
+                item.style.cursor = 'pointer';
+                item.innerHTML = '<div><strong>' + escapeHTML(chat.name || chat.peer) + '</strong>' +
+                    '<div class="inbox-meta">' + escapeHTML(chatPreview(chat.last)) + ' • ' +
+                    new Date(chat.updated).toLocaleString() + '</div></div>';
+                item.onclick = () => showChat(chat.peer);
+                container.appendChild(item);
+            });
+        }
+
+        async function showChat(peer) {
+            const response = await api('/api/chats/' + encodeURIComponent(peer));
+            if (!response.ok) {
+                currentChat = null;
+                document.getElementById('chatView').style.display = 'none';
+                return;
+            }
+            const chat = (await response.json()).chat;
+            const changed = !currentChat || currentChat.peer !== chat.peer ||
+                JSON.stringify(currentChat.entries) !== JSON.stringify(chat.entries);
+            currentChat = chat;
+            document.getElementById('chatView').style.display = 'block';
+            document.getElementById('chatTitle').textContent = chat.name || chat.peer;
+            if (!changed) {
+                return;
+            }
+
+            const timeline = document.getElementById('chatTimeline');
+            timeline.innerHTML = '';
+            chat.entries.forEach(entry => {
+                const item = document.createElement('div');
+                item.className = 'chat-entry' + (entry.outgoing ? ' outgoing' : '');
+                const body = document.createElement('div');
+                body.className = 'text-message';
+                if (entry.kind === 'file') {
+                    body.textContent = '📄 ' + entry.file + ' (' + formatFileSize(entry.size) + ')';
+                } else {
+                    linkify(body, entry.text);
+                }
+                const meta = document.createElement('div');
+                meta.className = 'inbox-meta';
+                meta.textContent = new Date(entry.time).toLocaleString() + ' • ' + (chatStatusLabels[entry.status] || entry.status) +
+                    (entry.error ? ': ' + entry.error : '');
+                item.appendChild(body);
+                item.appendChild(meta);
+                if (entry.outgoing && entry.kind === 'text' && entry.status === 'failed') {
+                    const retryBtn = document.createElement('button');
+                    retryBtn.textContent = 'Kirim ulang';
+                    retryBtn.onclick = () => sendChatMessage(entry.text, entry.id);
+                    meta.appendChild(retryBtn);
+                }
+                timeline.appendChild(item);
+            });
+            timeline.scrollTop = timeline.scrollHeight;
+        }
+
+        function chatTarget(chat) {
+            // Prefer where discovery currently sees the device, since its address may have changed
+            const device = discoveredDevices.find(d => d.id && d.id === chat.peer) ||
+                discoveredDevices.find(d => d.ip === chat.host && (!chat.port || d.port === chat.port));
+            if (device) {
+                return sendTarget(device);
+            }
+            if (chat.port) {
+                return { targetIP: chat.host, targetZone: '', targetPort: chat.port };
+            }
+            return null;
+        }
+
+        async function sendChatMessage(text, id) {
+            if (!currentChat || !text.trim()) {
+                return;
+            }
+            const target = chatTarget(currentChat);
+            if (!target) {
+                showStatus('Alamat ' + escapeHTML(currentChat.name || currentChat.peer) + ' belum diketahui, cari perangkat terlebih dahulu', 'error');
+                return;
+            }
+
+            const response = await api('/api/send-text', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify(Object.assign(target, { text: text, id: id || '' }))
+            });
+            if (!response.ok) {
+                showStatus('Gagal mengirim pesan: ' + escapeHTML(await response.text()), 'error');
+                return;
+            }
+            if (!id) {
+                document.getElementById('chatMessage').value = '';
+            }
+            await showChat(currentChat.peer);
+            loadChats();
+        }
+
+        async function clearChat() {
+            if (!currentChat || !confirm('Hapus riwayat percakapan dengan ' + (currentChat.name || currentChat.peer) + '?')) {
+                return;
+            }
+            await api('/api/chats/' + encodeURIComponent(currentChat.peer), { method: 'DELETE' });
+            currentChat = null;
+            document.getElementById('chatView').style.display = 'none';
+            loadChats();
+        }
+
+        async function loadGroups() {
+            const response = await api('/api/groups');
+            displayGroups((await response.json()).groups);
+        }
+
+        function displayGroups(groups) {
+            const container = document.getElementById('groupList');
+            container.innerHTML = '';
+
+            if (groups.length === 0) {
+                container.innerHTML = '<p style="color: #666; text-align: center; padding: 20px;">Belum ada grup</p>';
+                return;
+            }
+
+            groups.forEach(group => {
+                const online = group.members.filter(m => m.online);
+                const members = group.members.map(m => (m.online ? '🟢 ' + m.name : '⚪ ' + m.id));
+                const item = document.createElement('div');
+                item.className = 'inbox-item';
+                item.innerHTML = '<div><strong>' + escapeHTML(group.name) + '</strong> <code>@' + escapeHTML(group.slug) + '</code>' +
+                    '<div class="inbox-meta">' + online.length + '/' + group.members.length + ' online · ' + escapeHTML(members.join(', ')) + '</div></div>' +
+                    '<div class="inbox-actions"></div>';
+                const selectBtn = document.createElement('button');
+                selectBtn.textContent = 'Pilih';
+                selectBtn.onclick = () => selectGroup(group);
+                const removeBtn = document.createElement('button');
+                removeBtn.textContent = 'Hapus';
+                removeBtn.onclick = () => removeGroup(group.name);
+                item.querySelector('.inbox-actions').appendChild(selectBtn);
+                item.querySelector('.inbox-actions').appendChild(removeBtn);
+                container.appendChild(item);
+            });
+        }
+
+        function selectGroup(group) {
+            // Make the group's online members the send targets
+            const ids = group.members.filter(m => m.online).map(m => m.id);
+            selectedDevices = discoveredDevices.filter(d => d.fingerprint && ids.some(id => d.fingerprint.startsWith(id)));
+            displayDevices();
+            if (selectedDevices.length === 0) {
+                showStatus('Tidak ada anggota grup ' + escapeHTML(group.name) + ' yang online', 'error');
+                return;
+            }
+            showStatus('Perangkat dipilih: ' + selectedDevices.map(d => escapeHTML(d.name)).join(', '), 'info');
+        }
+
+        async function saveGroup() {
+            const name = document.getElementById('groupName').value.trim();
+            const members = selectedDevices.filter(d => d.id).map(d => d.id);
+            if (!name || members.length === 0) {
+                showStatus('Pilih perangkat dan isi nama grup terlebih dahulu', 'error');
+                return;
+            }
+            if (members.length < selectedDevices.length) {
+                showStatus('Perangkat tanpa ID (versi lama) tidak bisa dimasukkan ke grup', 'info');
+            }
+
+            const response = await api('/api/groups', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ name: name, members: members })
+            });
+            if (!response.ok) {
+                showStatus('Gagal menyimpan grup: ' + await response.text(), 'error');
+                return;
+            }
+            document.getElementById('groupName').value = '';
+            displayGroups((await response.json()).groups);
+        }
+
+        async function removeGroup(name) {
+            if (!confirm('Hapus grup "' + name + '"?')) {
+                return;
+            }
+            const response = await api('/api/groups', {
+                method: 'DELETE',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ name: name })
+            });
+            if (!response.ok) {
+                showStatus('Gagal menghapus grup: ' + await response.text(), 'error');
+                return;
+            }
+            displayGroups((await response.json()).groups);
+        }
+
+        function formatLimit(kbps) {
+            kbps = Number(kbps);
+            if (kbps === 0) {
+                return 'Tanpa batas';
+            }
+            return (kbps / 1024).toFixed(1) + ' MB/s';
+        }
+
+        async function loadLimits() {
+            const response = await api('/api/limits');
+            displayLimits((await response.json()).limits);
+        }
+
+        function displayLimits(limits) {
+            document.querySelectorAll('#bandwidthLimits input[type="range"]').forEach(slider => {
+                slider.value = limits[slider.dataset.limit];
+                slider.nextElementSibling.textContent = formatLimit(slider.value);
+                slider.oninput = () => {
+                    slider.nextElementSibling.textContent = formatLimit(slider.value);
+                };
+                slider.onchange = () => saveLimit(slider.dataset.limit, Number(slider.value));
+            });
+        }
+
+        async function saveLimit(name, kbps) {
+            const response = await api('/api/limits', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ [name]: kbps })
+            });
+            if (!response.ok) {
+                showStatus('Gagal menyimpan batas bandwidth: ' + await response.text(), 'error');
+                return;
+            }
+            displayLimits((await response.json()).limits);
+        }
+
+        async function deleteReceived(id) {
+            if (!confirm('Hapus file ini?')) {
+                return;
+            }
+
+            try {
+                const response = await api('/api/received/' + id, { method: 'DELETE' });
+                if (!response.ok) {
+                    throw new Error(await response.text());
+                }
+                loadInbox();
+            } catch (error) {
+                showStatus('Gagal menghapus file: ' + error.message, 'error');
+            }
+        }
+
+        function showStatus(message, type) {
+            const status = document.getElementById('status');
+            status.className = 'status ' + type;
+            status.innerHTML = message;
+            status.style.display = 'block';
+            
+            // Auto hide after 5 seconds for success messages
+            if (type === 'success') {
+                setTimeout(() => {
+                    status.style.display = 'none';
+                }, 5000);
+            }
+        }
+
+        // Auto-discover devices on page load
+        window.addEventListener('load', function() {
+            setTimeout(discoverDevices, 1000);
+            loadIdentity();
+            loadPolicy();
+            loadGroups();
+            loadLimits();
+            loadInbox();
+            loadQuarantine();
+            loadShares();
+            loadDrops();
+            setInterval(loadDrops, 2000);
+            loadTexts();
+            setInterval(loadTexts, 3000);
+            loadChats();
+            setInterval(loadChats, 3000);
+        });
+    </script>
+</body>
+</html>`
+const shareHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>LocalSend - Unduh File</title>
+    <style>
+        * {
+            margin: 0;
+            padding: 0;
+            box-sizing: border-box;
+        }
+
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif;
+            background: linear-gradient(135deg, #667eea 0%, #764ba2 100%);
+            min-height: 100vh;
+            padding: 20px;
+        }
+
+        .container {
+            max-width: 600px;
+            margin: 0 auto;
+            background: white;
+            border-radius: 15px;
+            box-shadow: 0 20px 40px rgba(0,0,0,0.1);
+            overflow: hidden;
+        }
+
+        .header {
+            background: linear-gradient(135deg, #4facfe 0%, #00f2fe 100%);
+            color: white;
+            padding: 25px;
+            text-align: center;
+        }
+
+        .content {
+            padding: 20px;
+        }
+
+        .file-item {
+            display: flex;
+            align-items: center;
+            justify-content: space-between;
+            background: #f8f9fa;
+            padding: 12px;
+            margin: 8px 0;
+            border-radius: 5px;
+            border-left: 4px solid #4facfe;
+            word-break: break-all;
+        }
+
+        .file-size {
+            color: #666;
+            font-size: 0.9em;
+        }
+
+        .btn {
+            display: inline-block;
+            background: linear-gradient(135deg, #4facfe 0%, #00f2fe 100%);
+            color: white;
+            padding: 10px 20px;
+            border-radius: 25px;
+            font-weight: 600;
+            text-decoration: none;
+            white-space: nowrap;
+            margin-left: 10px;
+        }
+
+        .footer {
+            color: #666;
+            font-size: 0.85em;
+            text-align: center;
+            margin-top: 15px;
+        }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <div class="header">
+            <h1>🚀 LocalSend</h1>
+            <p>{{len .Files}} file dibagikan untuk Anda</p>
+        </div>
+        <div class="content">
+            {{range $i, $f := .Files}}
+            <div class="file-item">
+                <div><strong>{{$f.Name}}</strong><div class="file-size">{{size $f.Size}}</div></div>
+                <a class="btn" href="/s/{{$.Token}}/{{$i}}">Unduh</a>
+            </div>
+            {{end}}
+            {{if gt (len .Files) 1}}
+            <p style="text-align: center; margin-top: 15px;">
+                <a class="btn" href="/s/{{.Token}}/zip">Unduh Semua (ZIP)</a>
+            </p>
+            {{end}}
+            <p class="footer">Tautan berlaku hingga {{.ExpiresAt.Format "02 Jan 2006 15:04"}}</p>
+        </div>
+    </div>
+</body>
+</html>`
+
+const dropHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>LocalSend - Kirim ke {{.DeviceName}}</title>
+    <style>
+        * {
+            margin: 0;
+            padding: 0;
+            box-sizing: border-box;
+        }
+
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif;
+            background: linear-gradient(135deg, #667eea 0%, #764ba2 100%);
+            min-height: 100vh;
+            padding: 20px;
+        }
+
+        .container {
+            max-width: 600px;
+            margin: 0 auto;
+            background: white;
+            border-radius: 15px;
+            box-shadow: 0 20px 40px rgba(0,0,0,0.1);
+            overflow: hidden;
+        }
+
+        .header {
+            background: linear-gradient(135deg, #4facfe 0%, #00f2fe 100%);
+            color: white;
+            padding: 25px;
+            text-align: center;
+        }
+
+        .content {
+            padding: 20px;
+        }
+
+        input[type="text"], input[type="password"] {
+            width: 100%;
+            padding: 10px;
+            margin: 5px 0 15px;
+            border: 1px solid #ccc;
+            border-radius: 5px;
+            font-size: 16px;
+        }
+
+        .file-label {
+            display: block;
+            background: #f0f0f0;
+            border: 2px dashed #ccc;
+            padding: 25px;
+            border-radius: 10px;
+            text-align: center;
+            cursor: pointer;
+            margin-bottom: 15px;
+        }
+
+        .btn {
+            width: 100%;
+            background: linear-gradient(135deg, #4facfe 0%, #00f2fe 100%);
+            color: white;
+            border: none;
+            padding: 12px;
+            border-radius: 25px;
+            font-size: 16px;
+            font-weight: 600;
+            cursor: pointer;
+        }
+
+        .btn:disabled {
+            opacity: 0.6;
+            cursor: not-allowed;
+        }
+
+        .progress {
+            height: 10px;
+            background: #e9ecef;
+            border-radius: 5px;
+            overflow: hidden;
+            margin-top: 15px;
+            display: none;
+        }
+
+        .progress-bar {
+            height: 100%;
+            width: 0;
+            background: linear-gradient(135deg, #4facfe 0%, #00f2fe 100%);
+            transition: width 0.2s ease;
+        }
+
+        .status {
+            margin-top: 15px;
+            color: #333;
+            text-align: center;
+        }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <div class="header">
+            <h1>🚀 LocalSend</h1>
+            <p>Kirim file ke {{.DeviceName}}</p>
+        </div>
+        <div class="content">
+            <label>Nama Anda<input type="text" id="name" placeholder="Ponsel saya"></label>
+            {{if .RequirePIN}}
+            <label>PIN<input type="password" id="pin" inputmode="numeric"></label>
+            {{end}}
+            <label class="file-label" for="files" id="fileLabel">📎 Pilih file</label>
+            <input type="file" id="files" multiple style="display: none;">
+            <button class="btn" id="sendBtn" onclick="send()">Kirim</button>
+            <div class="progress" id="progress"><div class="progress-bar" id="progressBar"></div></div>
+            <div class="status" id="status"></div>
+        </div>
+    </div>
+
+    <script>
+        const filesInput = document.getElementById('files');
+
+        filesInput.addEventListener('change', function() {
+            const count = filesInput.files.length;
+            document.getElementById('fileLabel').textContent = count > 0 ? '📎 ' + count + ' file dipilih' : '📎 Pilih file';
+        });
+
+        function setStatus(message) {
+            document.getElementById('status').textContent = message;
+        }
+
+        function sleep(ms) {
+            return new Promise(resolve => setTimeout(resolve, ms));
+        }
+
+        async function waitForConsent(id) {
+            while (true) {
+                const response = await fetch('/drop/request/' + id);
+                if (!response.ok) {
+                    throw new Error('Permintaan kedaluwarsa');
+                }
+                const data = await response.json();
+                if (data.request.status !== 'pending') {
+                    return data.request.status;
+                }
+                await sleep(1000);
+            }
+        }
+
+        function upload(id, files) {
+            return new Promise((resolve, reject) => {
+                const formData = new FormData();
+                Array.from(files).forEach(file => formData.append('files', file));
+
+                const xhr = new XMLHttpRequest();
+                xhr.open('POST', '/drop/upload/' + id);
+                xhr.upload.onprogress = function(e) {
+                    if (e.lengthComputable) {
+                        const percent = Math.round(e.loaded / e.total * 100);
+                        document.getElementById('progressBar').style.width = percent + '%';
+                        setStatus('Mengirim... ' + percent + '%');
+                    }
+                };
+                xhr.onload = function() {
+                    if (xhr.status === 200) {
+                        resolve();
+                    } else {
+                        reject(new Error(xhr.responseText));
+                    }
+                };
+                xhr.onerror = function() {
+                    reject(new Error('Koneksi terputus'));
+                };
+                xhr.send(formData);
+            });
+        }
+
+        async function send() {
+            const files = filesInput.files;
+            if (files.length === 0) {
+                setStatus('Pilih file terlebih dahulu');
+                return;
+            }
+
+            const btn = document.getElementById('sendBtn');
+            btn.disabled = true;
+
+            try {
+                const pinInput = document.getElementById('pin');
+                const response = await fetch('/drop/request', {
+                    method: 'POST',
+                    headers: {
+                        'Content-Type': 'application/json'
+                    },
+                    body: JSON.stringify({
+                        name: document.getElementById('name').value,
+                        pin: pinInput ? pinInput.value : '',
+                        files: Array.from(files).map(f => ({ name: f.name, size: f.size }))
+                    })
+                });
+
+                if (!response.ok) {
+                    const text = await response.text();
+                    let message = text;
+                    try {
+                        message = JSON.parse(text).error || text;
+                    } catch (e) {}
+                    throw new Error(message);
+                }
+
+                const data = await response.json();
+                let status = data.status;
+                if (status === 'pending') {
+                    setStatus('Menunggu persetujuan dari {{.DeviceName}}...');
+                    status = await waitForConsent(data.id);
+                }
+                if (status !== 'accepted') {
+                    throw new Error('Kiriman ditolak');
+                }
+
+                document.getElementById('progress').style.display = 'block';
+                await upload(data.id, files);
+                setStatus('✅ File berhasil dikirim!');
+            } catch (error) {
+                setStatus('❌ ' + error.message);
+            } finally {
+                btn.disabled = false;
+            }
+        }
     </script>
 </body>
-</html>`
\ No newline at end of file
+</html>`
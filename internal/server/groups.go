@@ -0,0 +1,240 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"localsend/internal/config"
+	"localsend/internal/discovery"
+)
+
+// GroupMember is a member of a group as shown to the user
+type GroupMember struct {
+	ID      string `json:"id"`
+	Name    string `json:"name,omitempty"`
+	Address string `json:"address,omitempty"`
+	Online  bool   `json:"online"`
+}
+
+// handleGroups lists, saves and removes device groups
+func (s *HTTPServer) handleGroups(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		s.respondGroups(w)
+		return
+	}
+
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request config.Group
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	request.Name = strings.TrimSpace(request.Name)
+	slug := config.GroupSlug(request.Name)
+	if slug == "" {
+		http.Error(w, "Group name must contain letters or digits", http.StatusBadRequest)
+		return
+	}
+
+	var members []string
+	if r.Method == http.MethodPost {
+		for _, id := range request.Members {
+			id = strings.ToLower(strings.TrimSpace(id))
+			if !config.ValidDeviceID(id) {
+				http.Error(w, fmt.Sprintf("Invalid device ID %q", id), http.StatusBadRequest)
+				return
+			}
+			if !containsString(members, id) {
+				members = append(members, id)
+			}
+		}
+	}
+
+	found := false
+	err := s.config.Update(func(c *config.Config) {
+		groups := make([]config.Group, 0, len(c.Groups)+1)
+		for _, group := range c.Groups {
+			if group.Slug() != slug {
+				groups = append(groups, group)
+				continue
+			}
+			found = true
+			if r.Method == http.MethodPost {
+				groups = append(groups, config.Group{Name: request.Name, Members: members})
+			}
+		}
+		if !found && r.Method == http.MethodPost {
+			groups = append(groups, config.Group{Name: request.Name, Members: members})
+		}
+		c.Groups = groups
+	})
+	if err != nil {
+		fmt.Printf("Error saving groups: %v\n", err)
+	}
+	if !found && r.Method == http.MethodDelete {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	}
+
+	s.respondGroups(w)
+}
+
+// respondGroups writes the groups with the current state of their members
+func (s *HTTPServer) respondGroups(w http.ResponseWriter) {
+	peers := s.discoveryService.GetPeers()
+	var configured []config.Group
+	s.config.View(func(c *config.Config) {
+		configured = c.Groups
+	})
+
+	groups := make([]map[string]interface{}, 0, len(configured))
+	for _, group := range configured {
+		members := make([]GroupMember, 0, len(group.Members))
+		for _, id := range group.Members {
+			member := GroupMember{ID: id}
+			if device := findMember(peers, id); device != nil {
+				member.Name = device.Name
+				member.Address = net.JoinHostPort(device.Host(), strconv.Itoa(device.Port))
+				member.Online = true
+			}
+			members = append(members, member)
+		}
+		groups = append(groups, map[string]interface{}{
+			"name":    group.Name,
+			"slug":    group.Slug(),
+			"members": members,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"groups":  groups,
+	})
+}
+
+// findMember returns the most recently seen peer whose key matches the
+// device ID, or nil when the device is offline. Only devices whose
+// announcement was freshly signed count, so that a replayed announcement
+// can't draw the group's files to another address.
+func findMember(peers []*discovery.Device, id string) *discovery.Device {
+	var found *discovery.Device
+	for _, device := range peers {
+		if device.Fingerprint == "" || !strings.HasPrefix(device.Fingerprint, id) {
+			continue
+		}
+		if !device.Verified && !device.Signed() {
+			continue
+		}
+		if found == nil || device.LastSeen.After(found.LastSeen) {
+			found = device
+		}
+	}
+	return found
+}
+
+// resolveName replaces a target naming a discovered device, e.g.
+// "designer-pc", by the device's address. Paired devices are preferred over
+// others of the same name; targets that are addresses or no device's name
+// are returned unchanged, to be resolved by DNS.
+func (s *HTTPServer) resolveName(target SendTarget) (SendTarget, error) {
+	if target.Group != "" || net.ParseIP(target.TargetIP) != nil {
+		return target, nil
+	}
+
+	var named, verified []*discovery.Device
+	for _, device := range s.discoveryService.GetPeers() {
+		if !strings.EqualFold(device.Name, target.TargetIP) {
+			continue
+		}
+		named = append(named, device)
+		if device.Verified {
+			verified = append(verified, device)
+		}
+	}
+	if len(verified) > 0 {
+		named = verified
+	}
+	switch len(named) {
+	case 0:
+		return target, nil
+	case 1:
+		device := named[0]
+		return SendTarget{TargetIP: device.IP, TargetZone: device.Zone, TargetPort: device.Port, device: device.ID}, nil
+	default:
+		return SendTarget{}, fmt.Errorf("%d devices are named %q, use an address instead", len(named), target.TargetIP)
+	}
+}
+
+// expandTargets replaces group targets by the group's online members and
+// drops duplicates, also when a device is named both by address and through
+// a group. Members that are offline are returned as failed statuses.
+func (s *HTTPServer) expandTargets(targets []SendTarget) ([]SendTarget, []*TargetStatus, error) {
+	peers := s.discoveryService.GetPeers()
+	var expanded []SendTarget
+	var offline []*TargetStatus
+	seen := make(map[string]bool)
+
+	add := func(target SendTarget) {
+		key := target.device
+		for _, device := range peers {
+			if key == "" && device.ID != "" && device.Host() == target.host() && device.Port == target.TargetPort {
+				key = device.ID
+			}
+		}
+		if key == "" {
+			key = net.JoinHostPort(target.host(), strconv.Itoa(target.TargetPort))
+		}
+		if !seen[key] {
+			seen[key] = true
+			expanded = append(expanded, target)
+		}
+	}
+
+	for _, target := range targets {
+		if target.Group == "" {
+			add(target)
+			continue
+		}
+
+		group, ok := s.config.FindGroup(target.Group)
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown group %q", target.Group)
+		}
+		for _, id := range group.Members {
+			device := findMember(peers, id)
+			if device == nil {
+				if !seen[id] {
+					seen[id] = true
+					offline = append(offline, &TargetStatus{
+						Target:  id,
+						Device:  id,
+						Results: []*TransferResult{},
+						Errors:  []string{"device is offline"},
+					})
+				}
+				continue
+			}
+			add(SendTarget{TargetIP: device.IP, TargetZone: device.Zone, TargetPort: device.Port, device: device.ID})
+		}
+	}
+	return expanded, offline, nil
+}
+
+// containsString reports whether list contains value
+func containsString(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
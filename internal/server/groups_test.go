@@ -0,0 +1,38 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"localsend/internal/discovery"
+)
+
+func TestFindMember(t *testing.T) {
+	const fingerprint = "3f9a0c5e8b1d7e42a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718"
+	const id = "3f9a0c5e8b1d7e42"
+	now := time.Now()
+
+	genuine := &discovery.Device{Name: "qa-1", IP: "192.168.1.20", Fingerprint: fingerprint, Verified: true, LastSeen: now.Add(-time.Minute)}
+	// An announcement replayed from another address, seen more recently
+	replayed := &discovery.Device{Name: "qa-1", IP: "192.168.1.66", Fingerprint: fingerprint, LastSeen: now}
+	unsigned := &discovery.Device{Name: "qa-1", IP: "192.168.1.67", LastSeen: now}
+	other := &discovery.Device{Name: "qa-2", IP: "192.168.1.21", Fingerprint: "8b1d7e42" + fingerprint[8:], Verified: true, LastSeen: now}
+
+	tests := []struct {
+		name  string
+		peers []*discovery.Device
+		want  *discovery.Device
+	}{
+		{"signed device", []*discovery.Device{genuine, other}, genuine},
+		{"replay seen later", []*discovery.Device{genuine, replayed, unsigned}, genuine},
+		{"replay seen first", []*discovery.Device{replayed, genuine}, genuine},
+		{"only a replay", []*discovery.Device{replayed, unsigned, other}, nil},
+		{"offline", []*discovery.Device{other}, nil},
+	}
+
+	for _, tt := range tests {
+		if got := findMember(tt.peers, id); got != tt.want {
+			t.Errorf("%s: findMember = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// inboxIndexFile stores sender metadata for received files inside downloadDir
+const inboxIndexFile = ".localsend-inbox.json"
+
+// senderHeader carries the sending device's name on transfer requests
+const senderHeader = "X-LocalSend-Sender"
+
+// InboxSender describes who sent a received file
+type InboxSender struct {
+	Name        string    `json:"name"`
+	IP          string    `json:"ip"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	ReceivedAt  time.Time `json:"receivedAt"`
+}
+
+// InboxEntry represents a file in the download directory
+type InboxEntry struct {
+	ID      string       `json:"id"`
+	Name    string       `json:"name"`
+	Size    int64        `json:"size"`
+	ModTime time.Time    `json:"modTime"`
+	Sender  *InboxSender `json:"sender,omitempty"`
+}
+
+// inboxIndex keeps track of who sent which file in downloadDir
+type inboxIndex struct {
+	path    string
+	senders map[string]*InboxSender
+	mutex   sync.Mutex
+}
+
+// newInboxIndex loads the sender index from downloadDir
+func newInboxIndex(downloadDir string) *inboxIndex {
+	idx := &inboxIndex{
+		path:    filepath.Join(downloadDir, inboxIndexFile),
+		senders: make(map[string]*InboxSender),
+	}
+
+	data, err := os.ReadFile(idx.path)
+	if err == nil {
+		if err := json.Unmarshal(data, &idx.senders); err != nil {
+			fmt.Printf("Error reading inbox index: %v\n", err)
+		}
+	}
+
+	return idx
+}
+
+// record stores the sender of a received file
+func (idx *inboxIndex) record(name string, sender *InboxSender) {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	idx.senders[name] = sender
+	idx.save()
+}
+
+// remove forgets the sender of a deleted file
+func (idx *inboxIndex) remove(name string) {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	delete(idx.senders, name)
+	idx.save()
+}
+
+// lookup returns the sender of a received file, if known
+func (idx *inboxIndex) lookup(name string) *InboxSender {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	return idx.senders[name]
+}
+
+// save writes the index to disk; callers must hold the mutex
+func (idx *inboxIndex) save() {
+	data, err := json.MarshalIndent(idx.senders, "", "  ")
+	if err != nil {
+		fmt.Printf("Error marshaling inbox index: %v\n", err)
+		return
+	}
+
+	if err := os.WriteFile(idx.path, data, 0644); err != nil {
+		fmt.Printf("Error writing inbox index: %v\n", err)
+	}
+}
+
+// inboxID returns a stable identifier for a file name in downloadDir
+func inboxID(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[:8])
+}
+
+// listInbox returns the files in the download directory, newest first
+func (s *HTTPServer) listInbox() ([]*InboxEntry, error) {
+	dirEntries, err := os.ReadDir(s.downloadDir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*InboxEntry, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() || strings.HasPrefix(dirEntry.Name(), ".") {
+			continue
+		}
+
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+
+		entries = append(entries, &InboxEntry{
+			ID:      inboxID(dirEntry.Name()),
+			Name:    dirEntry.Name(),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			Sender:  s.inbox.lookup(dirEntry.Name()),
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ModTime.After(entries[j].ModTime)
+	})
+
+	return entries, nil
+}
+
+// findInboxEntry looks up a received file by its identifier
+func (s *HTTPServer) findInboxEntry(id string) (*InboxEntry, error) {
+	entries, err := s.listInbox()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.ID == id {
+			return entry, nil
+		}
+	}
+
+	return nil, os.ErrNotExist
+}
+
+// handleReceivedList returns the files received into the download directory
+func (s *HTTPServer) handleReceivedList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	entries, err := s.listInbox()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list received files: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"files":   entries,
+	})
+}
+
+// handleReceivedFile downloads or deletes a single received file
+func (s *HTTPServer) handleReceivedFile(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/received/")
+	if id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	entry, err := s.findInboxEntry(id)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, fmt.Sprintf("Failed to list received files: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	path := filepath.Join(s.downloadDir, entry.Name)
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		file, err := os.Open(path)
+		if err != nil {
+			http.Error(w, "Failed to open file", http.StatusInternalServerError)
+			return
+		}
+		defer file.Close()
+
+		// ServeContent takes care of Range and conditional requests
+		w.Header().Set("Content-Disposition", contentDisposition(entry.Name))
+		http.ServeContent(s.throttleResponse(w, r), r, entry.Name, entry.ModTime, file)
+
+	case http.MethodDelete:
+		if err := os.Remove(path); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to delete file: %v", err), http.StatusInternalServerError)
+			return
+		}
+		s.inbox.remove(entry.Name)
+		fmt.Printf("Deleted received file: %s\n", path)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// contentDisposition builds an attachment header for a file name
+func contentDisposition(name string) string {
+	return fmt.Sprintf(`attachment; filename="%s"`, escapeQuotes(name))
+}
+
+// senderFromRequest extracts sender information from an incoming transfer
+func senderFromRequest(r *http.Request) *InboxSender {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	return &InboxSender{
+		Name:       r.Header.Get(senderHeader),
+		IP:         ip,
+		ReceivedAt: time.Now(),
+	}
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"localsend/internal/ratelimit"
+)
+
+// HTTPMetrics counts requests on the LAN listener
+type HTTPMetrics struct {
+	Requests          int64 `json:"requests"`
+	RateLimited       int64 `json:"rateLimited"`
+	Banned            int64 `json:"banned"`
+	TransfersRejected int64 `json:"transfersRejected"`
+}
+
+// newRequestLimiter allows bursts of twice the configured rate
+func newRequestLimiter(rate float64) *ratelimit.Limiter {
+	return ratelimit.NewLimiter(rate, int(rate*2))
+}
+
+// limitPeers rejects banned sources and sources sending too many requests
+func (s *HTTPServer) limitPeers(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := senderFromRequest(r).IP
+		atomic.AddInt64(&s.metrics.Requests, 1)
+
+		if s.bans.Banned(ip) {
+			atomic.AddInt64(&s.metrics.Banned, 1)
+			http.Error(w, "Temporarily banned", http.StatusForbidden)
+			return
+		}
+
+		if !s.requests.Allow(ip) {
+			atomic.AddInt64(&s.metrics.RateLimited, 1)
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acquireTransfer takes an incoming transfer slot for the request's source,
+// answering 429 and returning false when too many transfers are running.
+// Idle upload sessions are discarded first so they don't hold slots.
+func (s *HTTPServer) acquireTransfer(w http.ResponseWriter, r *http.Request) (func(), bool) {
+	s.sessions.prune()
+
+	ip := senderFromRequest(r).IP
+	if !s.transfers.Acquire(ip) {
+		atomic.AddInt64(&s.metrics.TransfersRejected, 1)
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "Too many concurrent transfers", http.StatusTooManyRequests)
+		return nil, false
+	}
+	return func() { s.transfers.Release(ip) }, true
+}
+
+// authFailed counts a failed authentication, such as a wrong PIN, against
+// the request's source
+func (s *HTTPServer) authFailed(r *http.Request, reason string) {
+	s.bans.Strike(senderFromRequest(r).IP, reason)
+}
+
+// handleMetrics reports rate limiting and abuse protection counters, and
+// lifts bans with DELETE /api/metrics/bans/{ip}
+func (s *HTTPServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if ip := strings.TrimPrefix(r.URL.Path, "/api/metrics/bans/"); ip != r.URL.Path {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		// Unban from both lists
+		unbanned := s.bans.Unban(ip)
+		if s.discoveryService.Bans().Unban(ip) {
+			unbanned = true
+		}
+		if !unbanned {
+			http.Error(w, "Not banned", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+		})
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"discovery": s.discoveryService.Metrics(),
+		"http": HTTPMetrics{
+			Requests:          atomic.LoadInt64(&s.metrics.Requests),
+			RateLimited:       atomic.LoadInt64(&s.metrics.RateLimited),
+			Banned:            atomic.LoadInt64(&s.metrics.Banned),
+			TransfersRejected: atomic.LoadInt64(&s.metrics.TransfersRejected),
+		},
+		"activeTransfers": s.transfers.Active(),
+		"bans":            s.bans.List(),
+		"discoveryBans":   s.discoveryService.Bans().List(),
+	})
+}
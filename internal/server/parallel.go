@@ -0,0 +1,350 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Files of at least parallelMinSize are sent in ranges over several
+// connections to peers announcing the parallel capability
+const (
+	parallelMinSize = 64 << 20
+	minChunkSize    = 32 << 20 // keeps the request rate well below the peer's rate limit
+	maxChunkSize    = 256 << 20
+	maxStreams      = 16
+	rangeAttempts   = 3
+	tuneInterval    = 500 * time.Millisecond
+)
+
+// An aborted session is deleted up to abortAttempts times, as the peer
+// refuses while it still receives the cancelled ranges
+const (
+	abortAttempts   = 5
+	abortRetryDelay = 200 * time.Millisecond
+)
+
+// chunkSize splits size into about four ranges per stream
+func chunkSize(size int64, streams int) int64 {
+	chunk := size / int64(streams*4)
+	if chunk < minChunkSize {
+		return minChunkSize
+	}
+	if chunk > maxChunkSize {
+		return maxChunkSize
+	}
+	return chunk
+}
+
+// initialStreams picks the number of streams to start with: more on links
+// with a higher round trip time, where one TCP stream fills less of the pipe
+func initialStreams(rtt time.Duration) int {
+	streams := 2 + int(rtt/(2*time.Millisecond))
+	if streams > maxStreams {
+		return maxStreams
+	}
+	return streams
+}
+
+// streamTuner adds streams while doing so still raises the throughput
+type streamTuner struct {
+	mutex sync.Mutex
+	bytes int64 // sent since the last evaluation
+	since time.Time
+	best  float64 // bytes per second
+	done  bool    // throughput stopped improving
+}
+
+// sent records a finished range and reports whether to start another stream
+func (t *streamTuner) sent(n int64) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.bytes += n
+	elapsed := time.Since(t.since)
+	if t.done || elapsed < tuneInterval {
+		return false
+	}
+
+	rate := float64(t.bytes) / elapsed.Seconds()
+	t.bytes, t.since = 0, time.Now()
+	if rate < t.best*1.1 {
+		t.done = true
+		return false
+	}
+	t.best = rate
+	return true
+}
+
+// rangeReader reads a range of a file through a handle of its own. It
+// exposes the descriptor, so net/http can still send it with sendfile, which
+// moves the file offset without calling Read.
+type rangeReader struct {
+	file *os.File
+	end  int64
+}
+
+// openRange opens path positioned at offset, reading n bytes
+func openRange(path string, offset, n int64) (*rangeReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &rangeReader{file: file, end: offset + n}, nil
+}
+
+func (rr *rangeReader) Read(p []byte) (int, error) {
+	pos, err := rr.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if pos >= rr.end {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > rr.end-pos {
+		p = p[:rr.end-pos]
+	}
+	return rr.file.Read(p)
+}
+
+func (rr *rangeReader) SyscallConn() (syscall.RawConn, error) {
+	return rr.file.SyscallConn()
+}
+
+func (rr *rangeReader) Close() error {
+	return rr.file.Close()
+}
+
+// sendParallel sends file in ranges written concurrently by the receiver
+func (s *HTTPServer) sendParallel(targetHost string, targetPort int, file *os.File, result *TransferResult) error {
+	start := time.Now()
+	sessionURL, err := s.openSession(targetHost, targetPort, result.File, result.Bytes)
+	if err != nil {
+		return err
+	}
+	rtt := time.Since(start)
+
+	streams, auto := s.config.TransferStreams, s.config.TransferStreams <= 0
+	if auto {
+		streams = initialStreams(rtt)
+	}
+	chunk := chunkSize(result.Bytes, streams)
+	if auto {
+		chunk = chunkSize(result.Bytes, maxStreams)
+	}
+
+	offsets := make(chan int64, result.Bytes/chunk+1)
+	for offset := int64(0); offset < result.Bytes; offset += chunk {
+		offsets <- offset
+	}
+	close(offsets)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var failed error
+	var failOnce sync.Once
+	var active int32
+	tuner := &streamTuner{since: time.Now()}
+
+	var startStream func()
+	startStream = func() {
+		if int(atomic.AddInt32(&active, 1)) > len(offsets)+1 {
+			atomic.AddInt32(&active, -1)
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for offset := range offsets {
+				n := chunk
+				if offset+n > result.Bytes {
+					n = result.Bytes - offset
+				}
+
+				wire, err := s.sendRange(ctx, targetHost, sessionURL, file, offset, n, result.Encoding)
+				if err != nil {
+					failOnce.Do(func() {
+						failed = err
+						cancel()
+					})
+					return
+				}
+				atomic.AddInt64(&result.WireBytes, wire)
+
+				if auto && atomic.LoadInt32(&active) < maxStreams && tuner.sent(n) {
+					startStream()
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < streams; i++ {
+		startStream()
+	}
+	wg.Wait()
+	result.Streams = int(atomic.LoadInt32(&active))
+
+	if failed != nil {
+		// Abort the session so the peer frees its transfer slot, once it
+		// noticed that the cancelled ranges are gone
+		var err error
+		for attempt := 1; attempt <= abortAttempts; attempt++ {
+			var resp *http.Response
+			resp, err = s.sessionRequest(http.MethodDelete, sessionURL)
+			if err != nil {
+				break
+			}
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusConflict && attempt < abortAttempts {
+				time.Sleep(abortRetryDelay)
+				continue
+			}
+			if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+				err = fmt.Errorf("server returned status: %s", resp.Status)
+			}
+			break
+		}
+		if err != nil {
+			fmt.Printf("Error aborting upload session %s: %v\n", sessionURL, err)
+		}
+		return failed
+	}
+
+	resp, err := s.sessionRequest(http.MethodPost, sessionURL+"/complete")
+	if err != nil {
+		return fmt.Errorf("failed to complete upload: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return refusal(resp)
+	}
+	return nil
+}
+
+// openSession asks the peer to receive a file in ranges and returns the
+// session's URL
+func (s *HTTPServer) openSession(targetHost string, targetPort int, name string, size int64) (string, error) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"name": name,
+		"size": size,
+	})
+
+	req, err := http.NewRequest(http.MethodPost, peerURL(targetHost, targetPort, "/upload/session"), bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(senderHeader, s.discoveryService.DeviceName())
+	s.discoveryService.SignRequest(req, s.discoveryService.DeviceName())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", refusal(resp)
+	}
+
+	var session struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&session); err != nil || session.ID == "" {
+		return "", fmt.Errorf("invalid upload session response")
+	}
+	return peerURL(targetHost, targetPort, "/upload/session/"+session.ID), nil
+}
+
+// sessionRequest sends a request without a body to an upload session
+func (s *HTTPServer) sessionRequest(method, url string) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return s.client.Do(req)
+}
+
+// sendRange sends n bytes of file at offset, retrying failed attempts. It
+// returns the bytes sent over the network.
+func (s *HTTPServer) sendRange(ctx context.Context, targetHost, sessionURL string, file *os.File, offset, n int64, encoding string) (int64, error) {
+	var err error
+	for attempt := 0; attempt < rangeAttempts && ctx.Err() == nil; attempt++ {
+		var wire int64
+		if wire, err = s.putRange(ctx, targetHost, sessionURL, file, offset, n, encoding); err == nil {
+			return wire, nil
+		}
+	}
+	return 0, err
+}
+
+// putRange sends one attempt of a range
+func (s *HTTPServer) putRange(ctx context.Context, targetHost, sessionURL string, file *os.File, offset, n int64, encoding string) (int64, error) {
+	var body io.Reader
+	wire := n
+	if encoding == "" {
+		rr, err := openRange(file.Name(), offset, n)
+		if err != nil {
+			return 0, err
+		}
+		body = rr
+		// Checked for each range, so a limit set during the transfer
+		// applies from the next range on
+		if s.uploads.Limited() {
+			body = struct {
+				io.Reader
+				io.Closer
+			}{s.uploads.Reader(targetHost, rr), rr}
+		}
+	} else {
+		wire = 0
+		section := io.NewSectionReader(file, offset, n)
+		pr, pw := io.Pipe()
+		gz := gzip.NewWriter(&countingWriter{writer: s.uploads.Writer(targetHost, pw), count: &wire})
+		go func() {
+			_, err := io.Copy(gz, section)
+			if err == nil {
+				err = gz.Close()
+			}
+			pw.CloseWithError(err)
+		}()
+		body = pr
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, sessionURL+"?offset="+strconv.FormatInt(offset, 10), body)
+	if err != nil {
+		return 0, err
+	}
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	} else {
+		req.ContentLength = n
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send range at %d: %v", offset, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, refusal(resp)
+	}
+	return atomic.LoadInt64(&wire), nil
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"localsend/internal/config"
+)
+
+// handlePeers lists peers, or adds and removes manually entered ones
+func (s *HTTPServer) handlePeers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.handleGetPeers(w, r)
+	case http.MethodPost:
+		s.handleAddPeer(w, r)
+	case http.MethodDelete:
+		s.handleRemovePeer(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAddPeer pins a peer by host/IP and port and stores it in the config
+func (s *HTTPServer) handleAddPeer(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Address string `json:"address"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	address := strings.TrimSpace(request.Address)
+
+	device, err := s.discoveryService.AddPinnedPeer(address)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to add peer: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	err = s.config.Update(func(c *config.Config) {
+		for _, existing := range c.Peers {
+			if existing == address {
+				return
+			}
+		}
+		c.Peers = append(append([]string(nil), c.Peers...), address)
+	})
+	if err != nil {
+		fmt.Printf("Error saving peer %s: %v\n", address, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"peer":    device,
+	})
+}
+
+// handleRemovePeer unpins a manually entered peer
+func (s *HTTPServer) handleRemovePeer(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Address string `json:"address"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if !s.discoveryService.RemovePinnedPeer(request.Address) {
+		http.Error(w, "Peer not found", http.StatusNotFound)
+		return
+	}
+
+	err := s.config.Update(func(c *config.Config) {
+		peers := make([]string, 0, len(c.Peers))
+		for _, existing := range c.Peers {
+			if existing != request.Address {
+				peers = append(peers, existing)
+			}
+		}
+		c.Peers = peers
+	})
+	if err != nil {
+		fmt.Printf("Error saving peers: %v\n", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
+
+// handleInfo describes this device to peers probing it over HTTP
+func (s *HTTPServer) handleInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.discoveryService.Info())
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"localsend/internal/config"
+	"localsend/internal/policy"
+)
+
+// reasonInvalidSignature is sent when a transfer request has a bad signature
+const reasonInvalidSignature = "invalid_signature"
+
+// refuse answers a peer with a JSON error carrying a machine-readable reason
+func refuse(w http.ResponseWriter, status int, reason, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": false,
+		"reason":  reason,
+		"error":   message,
+	})
+}
+
+// checkPolicy identifies the peer sending r under name and applies the allow
+// and block lists, answering 403 when it is refused
+func (s *HTTPServer) checkPolicy(w http.ResponseWriter, r *http.Request, name string) (policy.Peer, bool) {
+	fingerprint, err := s.discoveryService.VerifyRequest(r, name)
+	if err != nil {
+		s.authFailed(r, "invalid request signature")
+		refuse(w, http.StatusForbidden, reasonInvalidSignature, err.Error())
+		return policy.Peer{}, false
+	}
+
+	peer := policy.Peer{
+		Fingerprint: fingerprint,
+		IP:          senderFromRequest(r).IP,
+		Name:        name,
+	}
+
+	var decision policy.Decision
+	if fingerprint == "" {
+		decision = s.discoveryService.CheckUnsigned(peer)
+	} else {
+		decision = s.discoveryService.Policy().Check(peer)
+	}
+	if !decision.Allowed {
+		fmt.Printf("Refused transfer from %s (%s): %s\n", name, peer.IP, decision.Reason)
+		refuse(w, http.StatusForbidden, decision.Reason, "This device does not accept transfers from you")
+		return peer, false
+	}
+	return peer, true
+}
+
+// refusalError is a transfer a peer refused for a reason it gave
+type refusalError struct {
+	Reason  string
+	Message string
+}
+
+func (e *refusalError) Error() string {
+	return fmt.Sprintf("peer refused the transfer (%s): %s", e.Reason, e.Message)
+}
+
+// busyError is a request a peer turned away for now, as it was sent too
+// many requests or transfers
+type busyError struct {
+	Status     string
+	RetryAfter time.Duration
+}
+
+func (e *busyError) Error() string {
+	return fmt.Sprintf("server returned status: %s", e.Status)
+}
+
+// refusal turns an error response from a peer into an error, a *busyError
+// for 429 and a *refusalError if the peer gave a reason
+func refusal(resp *http.Response) error {
+	if resp.StatusCode == http.StatusTooManyRequests {
+		retry := time.Second
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			retry = time.Duration(seconds) * time.Second
+		}
+		return &busyError{Status: resp.Status, RetryAfter: retry}
+	}
+
+	var body struct {
+		Reason string `json:"reason"`
+		Error  string `json:"error"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if json.Unmarshal(data, &body) == nil && body.Reason != "" {
+		return &refusalError{Reason: body.Reason, Message: body.Error}
+	}
+	return fmt.Errorf("server returned status: %s", resp.Status)
+}
+
+// handlePolicy lists, adds and removes allow and block rules
+func (s *HTTPServer) handlePolicy(w http.ResponseWriter, r *http.Request) {
+	var allow, block []config.PolicyRule
+	if r.Method == http.MethodGet {
+		s.config.View(func(c *config.Config) {
+			allow, block = c.Allow, c.Block
+		})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"allow":   allow,
+			"block":   block,
+		})
+		return
+	}
+
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		List string            `json:"list"` // "allow" or "block"
+		Rule config.PolicyRule `json:"rule"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.List != "allow" && request.List != "block" {
+		http.Error(w, "List must be allow or block", http.StatusBadRequest)
+		return
+	}
+	// Invalid rules written into the configuration file can still be removed
+	if err := policy.Validate(&request.Rule); err != nil && r.Method == http.MethodPost {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := s.config.Update(func(c *config.Config) {
+		list := &c.Allow
+		if request.List == "block" {
+			list = &c.Block
+		}
+
+		rules := make([]config.PolicyRule, 0, len(*list)+1)
+		for _, rule := range *list {
+			if rule != request.Rule {
+				rules = append(rules, rule)
+			}
+		}
+		if r.Method == http.MethodPost {
+			rules = append(rules, request.Rule)
+		}
+		*list = rules
+
+		s.discoveryService.Policy().Set(c.Allow, c.Block)
+		allow, block = c.Allow, c.Block
+	})
+	if err != nil {
+		fmt.Printf("Error saving policy: %v\n", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"allow":   allow,
+		"block":   block,
+	})
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// fileNameHeader carries the path-escaped name of a file sent as a raw body
+const fileNameHeader = "X-LocalSend-File-Name"
+
+// copyBufferSize is the buffer used to write received files, larger than
+// io.Copy's 32 KB to cut down on write syscalls
+const copyBufferSize = 1 << 20
+
+var copyBuffers = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, copyBufferSize)
+		return &b
+	},
+}
+
+// copyFile copies src into dst through a pooled buffer
+func copyFile(dst *os.File, src io.Reader) (int64, error) {
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+
+	// Hide dst's ReadFrom, which would fall back to a 32 KB buffer for
+	// readers other than files and sockets
+	return io.CopyBuffer(struct{ io.Writer }{dst}, src, *buf)
+}
+
+// handleReceiveRaw receives a single file sent as the request body with
+// POST /upload/raw. Unlike multipart uploads, this lets the sender hand the
+// file to the kernel with sendfile.
+func (s *HTTPServer) handleReceiveRaw(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	peer, ok := s.checkPolicy(w, r, r.Header.Get(senderHeader))
+	if !ok {
+		return
+	}
+
+	name, err := url.PathUnescape(r.Header.Get(fileNameHeader))
+	name = filepath.Base(name)
+	if err != nil || name == "." || name == string(filepath.Separator) {
+		http.Error(w, "Invalid file name", http.StatusBadRequest)
+		return
+	}
+
+	release, ok := s.acquireTransfer(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	sender := senderFromRequest(r)
+	sender.Fingerprint = peer.Fingerprint
+
+	budget, serr := s.newBudget(s.quotaKey(peer), announcedSize(r))
+	if serr != nil {
+		fmt.Printf("Refused transfer from %s (%s): %s\n", sender.Name, sender.IP, serr.reason)
+		refuseStorage(w, serr)
+		return
+	}
+	defer budget.done()
+
+	s.throttleBody(r)
+	body, err := decodeBody(r)
+	if err != nil {
+		refuse(w, http.StatusUnsupportedMediaType, reasonUnsupportedEncoding, err.Error())
+		return
+	}
+	defer body.Close()
+
+	destPath := s.receivePath(name)
+	n, err := budget.save(body, destPath, nil)
+	if serr, ok := err.(*storageError); ok {
+		fmt.Printf("Refused %s from %s (%s): %s\n", name, sender.Name, sender.IP, serr.reason)
+		refuseStorage(w, serr)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error saving %s: %v\n", name, err)
+		http.Error(w, "Error saving file", http.StatusBadRequest)
+		return
+	}
+
+	fmt.Printf("Wrote %s (%d bytes)\n", destPath, n)
+	result := receiveResult{Files: []string{}}
+	result.add(s.screenPeerFile(destPath, sender))
+	s.respondReceived(w, &result)
+}
+
+// sendRaw sends file uncompressed as the request body, which net/http
+// copies to the socket with sendfile on Linux
+func (s *HTTPServer) sendRaw(targetHost string, targetPort int, file *os.File, result *TransferResult) error {
+	// NopCloser keeps the transport from closing file and is unwrapped by
+	// net/http, so the body still reaches the socket as an *os.File. With an
+	// upload limit the file is read through the throttle instead; a limit
+	// set once sendfile is running doesn't apply to this file.
+	var body io.Reader = io.NopCloser(file)
+	if s.uploads.Limited() {
+		body = s.uploads.Reader(targetHost, file)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, peerURL(targetHost, targetPort, "/upload/raw"), body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+
+	req.ContentLength = result.Bytes
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set(fileNameHeader, url.PathEscape(result.File))
+	req.Header.Set(senderHeader, s.discoveryService.DeviceName())
+	req.Header.Set(totalSizeHeader, strconv.FormatInt(result.Bytes, 10))
+	s.discoveryService.SignRequest(req, s.discoveryService.DeviceName())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return refusal(resp)
+	}
+	result.WireBytes = result.Bytes
+	return nil
+}
@@ -0,0 +1,363 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"localsend/internal/quarantine"
+)
+
+// Folders inside downloadDir for files that are being or have been screened
+const (
+	incomingDir         = ".localsend-incoming"
+	quarantineDir       = ".quarantine"
+	quarantineIndexFile = ".localsend-quarantine.json"
+)
+
+// reasonFileTypeDenied is sent when every file of an upload was refused by type
+const reasonFileTypeDenied = "file_type_denied"
+
+// reasonQuarantined is sent when a file synced into a folder was quarantined
+const reasonQuarantined = "quarantined"
+
+// What happened to a received file
+const (
+	outcomeReceived    = "received"
+	outcomeQuarantined = "quarantined"
+	outcomeRejected    = "rejected"
+	outcomeScanning    = "scanning"
+)
+
+// receiveResult collects the outcome of each file of an upload
+type receiveResult struct {
+	Files       []string `json:"files"`
+	Quarantined []string `json:"quarantined,omitempty"`
+	Rejected    []string `json:"rejected,omitempty"`
+	Scanning    []string `json:"scanning,omitempty"`
+}
+
+func (rr *receiveResult) add(outcome, name string) {
+	switch outcome {
+	case outcomeReceived:
+		rr.Files = append(rr.Files, name)
+	case outcomeQuarantined:
+		rr.Quarantined = append(rr.Quarantined, name)
+	case outcomeRejected:
+		rr.Rejected = append(rr.Rejected, name)
+	case outcomeScanning:
+		rr.Scanning = append(rr.Scanning, name)
+	}
+}
+
+// accepted returns how many files were kept in some form
+func (rr *receiveResult) accepted() int {
+	return len(rr.Files) + len(rr.Quarantined) + len(rr.Scanning)
+}
+
+// QuarantineEntry is a received file held back by the file rules or scanner
+type QuarantineEntry struct {
+	ID            string       `json:"id"`
+	Name          string       `json:"name"`
+	Size          int64        `json:"size"`
+	Reason        string       `json:"reason"`
+	Sender        *InboxSender `json:"sender,omitempty"`
+	QuarantinedAt time.Time    `json:"quarantinedAt"`
+}
+
+// quarantineIndex keeps track of the files in the quarantine folder
+type quarantineIndex struct {
+	path    string
+	entries map[string]*QuarantineEntry
+	mutex   sync.Mutex
+}
+
+// newQuarantineIndex loads the quarantine index from downloadDir
+func newQuarantineIndex(downloadDir string) *quarantineIndex {
+	idx := &quarantineIndex{
+		path:    filepath.Join(downloadDir, quarantineDir, quarantineIndexFile),
+		entries: make(map[string]*QuarantineEntry),
+	}
+
+	data, err := os.ReadFile(idx.path)
+	if err == nil {
+		if err := json.Unmarshal(data, &idx.entries); err != nil {
+			fmt.Printf("Error reading quarantine index: %v\n", err)
+		}
+	}
+
+	return idx
+}
+
+// add records a quarantined file
+func (idx *quarantineIndex) add(entry *QuarantineEntry) {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	idx.entries[entry.Name] = entry
+	idx.save()
+}
+
+// remove forgets a released or deleted file
+func (idx *quarantineIndex) remove(name string) {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	delete(idx.entries, name)
+	idx.save()
+}
+
+// list returns the quarantined files, newest first
+func (idx *quarantineIndex) list() []*QuarantineEntry {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	entries := make([]*QuarantineEntry, 0, len(idx.entries))
+	for _, entry := range idx.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].QuarantinedAt.After(entries[j].QuarantinedAt)
+	})
+	return entries
+}
+
+// find looks up a quarantined file by its identifier
+func (idx *quarantineIndex) find(id string) *QuarantineEntry {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	for _, entry := range idx.entries {
+		if entry.ID == id {
+			return entry
+		}
+	}
+	return nil
+}
+
+// save writes the index to disk; callers must hold the mutex
+func (idx *quarantineIndex) save() {
+	data, err := json.MarshalIndent(idx.entries, "", "  ")
+	if err != nil {
+		fmt.Printf("Error marshaling quarantine index: %v\n", err)
+		return
+	}
+
+	if err := os.WriteFile(idx.path, data, 0644); err != nil {
+		fmt.Printf("Error writing quarantine index: %v\n", err)
+	}
+}
+
+// SetScanner installs the scanner that checks received files, replacing
+// the configured scan command
+func (s *HTTPServer) SetScanner(scanner quarantine.Scanner) {
+	s.scanner = scanner
+}
+
+// respondReceived answers a completed upload with what happened to its files,
+// or 403 if every file was rejected for its type
+func (s *HTTPServer) respondReceived(w http.ResponseWriter, result *receiveResult) {
+	if result.accepted() == 0 && len(result.Rejected) > 0 {
+		refuse(w, http.StatusForbidden, reasonFileTypeDenied, "This device does not accept files of this type")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"message":     fmt.Sprintf("Received %d files", result.accepted()),
+		"files":       result.Files,
+		"quarantined": result.Quarantined,
+		"rejected":    result.Rejected,
+		"scanning":    result.Scanning,
+	})
+}
+
+// screening reports whether received files are checked before they are
+// moved into the download directory
+func (s *HTTPServer) screening() bool {
+	return !s.fileRules.Empty() || s.scanner != nil
+}
+
+// receivePath returns where a received file called name is written: straight
+// into the download directory, or a staging folder if it is screened first
+func (s *HTTPServer) receivePath(name string) string {
+	if !s.screening() {
+		return uniquePath(filepath.Join(s.downloadDir, name))
+	}
+
+	dir := filepath.Join(s.downloadDir, incomingDir)
+	os.MkdirAll(dir, 0755)
+	return uniquePath(filepath.Join(dir, name))
+}
+
+// screenReceived applies the file rules to a file written at receivePath and
+// starts scanning it. It returns what happened to the file and its name; if
+// the file is being scanned, scanned is called with the outcome later.
+func (s *HTTPServer) screenReceived(path string, sender *InboxSender, scanned func(outcome, name string)) (string, string) {
+	name := filepath.Base(path)
+	if !s.screening() {
+		s.inbox.record(name, sender)
+		return outcomeReceived, name
+	}
+
+	action, pattern := s.fileRules.Classify(name, sniff(path))
+	switch action {
+	case quarantine.Deny:
+		os.Remove(path)
+		fmt.Printf("Rejected received file %s (%s)\n", name, pattern)
+		return outcomeRejected, name
+	case quarantine.Quarantine:
+		return outcomeQuarantined, s.quarantineFile(path, sender, "file type "+pattern)
+	}
+
+	if s.scanner == nil {
+		name, _ = s.moveIntoDownloads(path, sender)
+		return outcomeReceived, name
+	}
+
+	go s.scanReceived(path, sender, scanned)
+	return outcomeScanning, name
+}
+
+// sniff returns the start of the file at path, to detect its type
+func sniff(path string) []byte {
+	head := make([]byte, quarantine.SniffLen)
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	n, _ := io.ReadFull(file, head)
+	return head[:n]
+}
+
+// scanReceived moves a staged file into the download directory if the
+// scanner finds it clean, and into quarantine otherwise
+func (s *HTTPServer) scanReceived(path string, sender *InboxSender, scanned func(outcome, name string)) {
+	result, err := s.scanner.Scan(context.Background(), path)
+	outcome, name := outcomeQuarantined, ""
+	switch {
+	case err != nil:
+		name = s.quarantineFile(path, sender, err.Error())
+	case !result.Clean:
+		name = s.quarantineFile(path, sender, "scanner: "+result.Detail)
+	default:
+		outcome = outcomeReceived
+		name, _ = s.moveIntoDownloads(path, sender)
+	}
+	if scanned != nil {
+		scanned(outcome, name)
+	}
+}
+
+// moveIntoDownloads moves a screened file into the download directory
+func (s *HTTPServer) moveIntoDownloads(path string, sender *InboxSender) (string, error) {
+	destPath := uniquePath(filepath.Join(s.downloadDir, filepath.Base(path)))
+	if err := os.Rename(path, destPath); err != nil {
+		fmt.Printf("Error moving %s into downloads: %v\n", path, err)
+		return filepath.Base(path), err
+	}
+
+	s.inbox.record(filepath.Base(destPath), sender)
+	fmt.Printf("Received file: %s\n", destPath)
+	return filepath.Base(destPath), nil
+}
+
+// quarantineFile moves a file into the quarantine folder
+func (s *HTTPServer) quarantineFile(path string, sender *InboxSender, reason string) string {
+	dir := filepath.Join(s.downloadDir, quarantineDir)
+	os.MkdirAll(dir, 0700)
+
+	destPath := uniquePath(filepath.Join(dir, filepath.Base(path)))
+	if err := os.Rename(path, destPath); err != nil {
+		fmt.Printf("Error quarantining %s: %v\n", path, err)
+		os.Remove(path)
+		return filepath.Base(path)
+	}
+
+	var size int64
+	if info, err := os.Stat(destPath); err == nil {
+		size = info.Size()
+	}
+
+	name := filepath.Base(destPath)
+	s.quarantined.add(&QuarantineEntry{
+		ID:            inboxID(name),
+		Name:          name,
+		Size:          size,
+		Reason:        reason,
+		Sender:        sender,
+		QuarantinedAt: time.Now(),
+	})
+	fmt.Printf("Quarantined received file %s: %s\n", name, reason)
+	return name
+}
+
+// handleQuarantineList returns the quarantined files
+func (s *HTTPServer) handleQuarantineList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"files":   s.quarantined.list(),
+	})
+}
+
+// handleQuarantineFile releases a quarantined file into the download
+// directory (POST /api/quarantine/{id}/release) or deletes it
+func (s *HTTPServer) handleQuarantineFile(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/quarantine/")
+	id, action, _ := strings.Cut(rest, "/")
+
+	entry := s.quarantined.find(id)
+	if entry == nil {
+		http.NotFound(w, r)
+		return
+	}
+	path := filepath.Join(s.downloadDir, quarantineDir, entry.Name)
+
+	switch {
+	case action == "release" && r.Method == http.MethodPost:
+		name, err := s.moveIntoDownloads(path, entry.Sender)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to release file: %v", err), http.StatusInternalServerError)
+			return
+		}
+		s.quarantined.remove(entry.Name)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"name":    name,
+		})
+
+	case action == "" && r.Method == http.MethodDelete:
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			http.Error(w, fmt.Sprintf("Failed to delete file: %v", err), http.StatusInternalServerError)
+			return
+		}
+		s.quarantined.remove(entry.Name)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -4,33 +4,127 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
+	"localsend/internal/config"
 	"localsend/internal/discovery"
+	"localsend/internal/quarantine"
+	"localsend/internal/ratelimit"
 )
 
 // HTTPServer handles HTTP requests
 type HTTPServer struct {
-	port            int
-	downloadDir     string
+	port             int
+	downloadDir      string
+	config           *config.Config
 	discoveryService *discovery.Service
-	server          *http.Server
+	server           *http.Server // LAN-facing listener
+	adminServer      *http.Server // web interface and control API
+	inbox            *inboxIndex
+	chats            *chatLog // conversation with each peer
+	shares           *shareStore
+	drops            *dropStore
+	sessions         *sessionStore      // incoming uploads sent in ranges
+	texts            *textInbox         // received text messages
+	watcher          *folderWatcher     // folders sent automatically
+	hashes           *hashCache         // of synced files
+	syncRefused      *syncRefusals      // synced files peers won't take
+	client           *http.Client       // outgoing transfers
+	requests         *ratelimit.Limiter // per-address LAN request rate
+	bans             *ratelimit.BanList // addresses failing authentication on the LAN listener
+	transfers        *ratelimit.Slots   // concurrent incoming transfers
+	quota            *ratelimit.Quota   // bytes received per peer
+	uploads          *ratelimit.Throttle
+	downloads        *ratelimit.Throttle
+	fileRules        *quarantine.Rules
+	scanner          quarantine.Scanner // nil when received files aren't scanned
+	quarantined      *quarantineIndex
+	metrics          HTTPMetrics
 }
 
 // NewHTTPServer creates a new HTTP server
-func NewHTTPServer(port int, downloadDir string, discoveryService *discovery.Service) *HTTPServer {
-	return &HTTPServer{
-		port:            port,
-		downloadDir:     downloadDir,
+func NewHTTPServer(cfg *config.Config, discoveryService *discovery.Service) *HTTPServer {
+	discoveryService.SetCapabilities(capabilities())
+
+	s := &HTTPServer{
+		port:             cfg.HTTPPort,
+		downloadDir:      cfg.DownloadDir,
+		config:           cfg,
 		discoveryService: discoveryService,
+		inbox:            newInboxIndex(cfg.DownloadDir),
+		chats:            newChatLog(cfg.DownloadDir),
+		shares:           newShareStore(),
+		drops:            newDropStore(),
+		sessions:         newSessionStore(),
+		texts:            &textInbox{},
+		hashes:           newHashCache(),
+		syncRefused:      &syncRefusals{refused: make(map[string]string)},
+		client:           newTransferClient(),
+		requests:         newRequestLimiter(cfg.RequestRate),
+		bans:             ratelimit.NewBanList(cfg.BanThreshold, time.Minute, time.Duration(cfg.BanMinutes)*time.Minute),
+		transfers:        ratelimit.NewSlots(cfg.MaxTransfersPerPeer, cfg.MaxTransfers),
+		quota:            ratelimit.NewQuota(megabytes(cfg.PeerQuotaMB), peerQuotaWindow),
+		uploads:          ratelimit.NewThrottle(kilobytes(cfg.UploadLimitKBps), kilobytes(cfg.PeerUploadLimitKBps)),
+		downloads:        ratelimit.NewThrottle(kilobytes(cfg.DownloadLimitKBps), kilobytes(cfg.PeerDownloadLimitKBps)),
+		fileRules: &quarantine.Rules{
+			Allow:      cfg.AllowTypes,
+			Deny:       cfg.DenyTypes,
+			Quarantine: cfg.QuarantineTypes,
+		},
+		quarantined: newQuarantineIndex(cfg.DownloadDir),
+	}
+
+	s.watcher = newFolderWatcher(s, cfg)
+
+	if len(cfg.ScanCommand) > 0 {
+		s.scanner = &quarantine.CommandScanner{
+			Command: cfg.ScanCommand,
+			Timeout: time.Duration(cfg.ScanTimeoutSeconds) * time.Second,
+		}
 	}
+
+	return s
 }
 
-// Start starts the HTTP server
+// Start starts the admin and LAN-facing HTTP listeners
 func (s *HTTPServer) Start() error {
+	s.adminServer = &http.Server{
+		Addr:    s.config.AdminAddr,
+		Handler: s.protectAdmin(s.adminMux()),
+	}
+
+	s.server = &http.Server{
+		Addr:    fmt.Sprintf(":%d", s.port),
+		Handler: s.limitPeers(s.peerMux()),
+	}
+
+	errChan := make(chan error, 2)
+
+	go s.watcher.run()
+
+	go func() {
+		fmt.Printf("Admin interface starting on %s\n", s.config.AdminAddr)
+		errChan <- s.adminServer.ListenAndServe()
+	}()
+
+	go func() {
+		fmt.Printf("HTTP server starting on port %d\n", s.port)
+		errChan <- s.server.ListenAndServe()
+	}()
+
+	return <-errChan
+}
+
+// adminMux serves the web interface and the control API, which must only be
+// reachable from this machine
+func (s *HTTPServer) adminMux() *http.ServeMux {
 	mux := http.NewServeMux()
 
 	// Serve static files (frontend)
@@ -39,30 +133,99 @@ func (s *HTTPServer) Start() error {
 
 	// API endpoints
 	mux.HandleFunc("/api/discover", s.handleDiscover)
-	mux.HandleFunc("/api/peers", s.handleGetPeers)
+	mux.HandleFunc("/api/peers", s.handlePeers)
+	mux.HandleFunc("/api/trust", s.handleTrust)
+	mux.HandleFunc("/api/groups", s.handleGroups)
+	mux.HandleFunc("/api/policy", s.handlePolicy)
+	mux.HandleFunc("/api/metrics", s.handleMetrics)
+	mux.HandleFunc("/api/metrics/", s.handleMetrics)
+	mux.HandleFunc("/api/limits", s.handleLimits)
 	mux.HandleFunc("/api/upload", s.handleUpload)
 	mux.HandleFunc("/api/send", s.handleSendFile)
+	mux.HandleFunc("/api/send-text", s.handleSendText)
+	mux.HandleFunc("/api/texts", s.handleTexts)
+	mux.HandleFunc("/api/texts/", s.handleText)
+	mux.HandleFunc("/api/chats", s.handleChats)
+	mux.HandleFunc("/api/chats/", s.handleChat)
+	mux.HandleFunc("/api/watch", s.handleWatch)
+	mux.HandleFunc("/api/sync", s.handleSync)
+	mux.HandleFunc("/api/received", s.handleReceivedList)
+	mux.HandleFunc("/api/received/", s.handleReceivedFile)
+	mux.HandleFunc("/api/quarantine", s.handleQuarantineList)
+	mux.HandleFunc("/api/quarantine/", s.handleQuarantineFile)
+	mux.HandleFunc("/api/shares", s.handleShares)
+	mux.HandleFunc("/api/shares/", s.handleShare)
+	mux.HandleFunc("/api/drops", s.handleDrops)
+	mux.HandleFunc("/api/drops/", s.handleDropDecision)
+
+	return mux
+}
+
+// peerMux serves the endpoints other devices and browsers on the LAN use
+func (s *HTTPServer) peerMux() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/", s.handlePeerIndex)
+
+	// Device details for peers added by address
+	mux.HandleFunc("/api/info", s.handleInfo)
+
+	// Share links for browsers without the app
+	mux.HandleFunc("/s/", s.handlePublicShare)
+
+	// Upload page for browsers without the app
+	mux.HandleFunc("/drop", s.handleDropPage)
+	mux.HandleFunc("/drop/", s.handleDropAPI)
 
 	// File upload endpoint (for receiving files from other devices)
 	mux.HandleFunc("/upload", s.handleReceiveFile)
+	mux.HandleFunc("/upload/raw", s.handleReceiveRaw)
+	mux.HandleFunc("/upload/session", s.handleUploadSession)
+	mux.HandleFunc("/upload/session/", s.handleUploadSessionRange)
 
-	s.server = &http.Server{
-		Addr:    fmt.Sprintf(":%d", s.port),
-		Handler: mux,
-	}
+	// Text messages from other devices
+	mux.HandleFunc("/text", s.handleReceiveText)
+
+	// Folder sync: the sender's manifest is compared here, files go to /upload
+	mux.HandleFunc("/sync/manifest", s.handleSyncManifest)
 
-	fmt.Printf("HTTP server starting on port %d\n", s.port)
-	return s.server.ListenAndServe()
+	return mux
 }
 
-// Stop stops the HTTP server
+// Stop stops the HTTP servers
 func (s *HTTPServer) Stop() {
+	s.watcher.Stop()
+	s.chats.flush()
+	if s.adminServer != nil {
+		s.adminServer.Close()
+	}
 	if s.server != nil {
 		s.server.Close()
 		fmt.Println("HTTP server stopped")
 	}
 }
 
+// handlePeerIndex points visitors of the LAN listener to the right page
+func (s *HTTPServer) handlePeerIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Local users probably wanted the admin interface
+	if ip := net.ParseIP(senderFromRequest(r).IP); ip != nil && ip.IsLoopback() {
+		http.Redirect(w, r, s.AdminURL(), http.StatusFound)
+		return
+	}
+
+	http.Redirect(w, r, "/drop", http.StatusFound)
+}
+
+// AdminURL returns the address of the web interface
+func (s *HTTPServer) AdminURL() string {
+	return s.config.AdminURL()
+}
+
 // handleIndex serves the main HTML page
 func (s *HTTPServer) handleIndex(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != "/" {
@@ -71,7 +234,8 @@ func (s *HTTPServer) handleIndex(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "text/html")
-	w.Write([]byte(indexHTML))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte(strings.Replace(indexHTML, tokenPlaceholder, s.config.APIToken, 1)))
 }
 
 // handleStatic serves static files
@@ -87,7 +251,8 @@ func (s *HTTPServer) handleDiscover(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	devices, err := s.discoveryService.DiscoverDevices()
+	sweep := r.URL.Query().Get("sweep") == "1"
+	devices, err := s.discoveryService.DiscoverDevices(sweep)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Discovery failed: %v", err), http.StatusInternalServerError)
 		return
@@ -186,9 +351,9 @@ func (s *HTTPServer) handleSendFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var request struct {
-		TargetIP   string   `json:"targetIP"`
-		TargetPort int      `json:"targetPort"`
-		FilePaths  []string `json:"filePaths"`
+		SendTarget
+		Targets   []SendTarget `json:"targets"` // several peers, each file read once for all
+		FilePaths []string     `json:"filePaths"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
@@ -196,22 +361,65 @@ func (s *HTTPServer) handleSendFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Send files to target device
+	if request.Group != "" {
+		request.Targets = append(request.Targets, request.SendTarget)
+	}
+
+	if len(request.Targets) > 0 {
+		targets, offline, err := s.expandTargets(request.Targets)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		statuses := []*TargetStatus{}
+		if len(targets) > 0 {
+			statuses = s.sendToTargets(targets, request.FilePaths)
+		}
+		statuses = append(statuses, offline...)
+
+		success := len(statuses) > 0
+		for _, status := range statuses {
+			success = success && status.Success
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": success,
+			"targets": statuses,
+		})
+		return
+	}
+
+	targetHost := request.host()
+
+	mode := s.negotiateTransfer(targetHost, request.TargetPort)
+
+	// Send files to target device, several at once
+	results := make([]*TransferResult, len(request.FilePaths))
+	sendErrors := make([]error, len(request.FilePaths))
+
+	s.eachFile(len(request.FilePaths), func(i int) {
+		results[i], sendErrors[i] = s.sendFileToDevice(targetHost, request.TargetPort, request.FilePaths[i], mode)
+	})
+
 	success := true
 	var errors []string
-
-	for _, filePath := range request.FilePaths {
-		err := s.sendFileToDevice(request.TargetIP, request.TargetPort, filePath)
+	for i, err := range sendErrors {
 		if err != nil {
 			success = false
-			errors = append(errors, fmt.Sprintf("Failed to send %s: %v", filepath.Base(filePath), err))
+			errors = append(errors, fmt.Sprintf("Failed to send %s: %v", filepath.Base(request.FilePaths[i]), err))
+			results[i].Error = err.Error()
 		}
 	}
+	s.recordSent(s.targetPeer(targetHost, request.TargetPort), results)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"success": success,
 		"errors":  errors,
+		"mode":    mode.Name,
+		"results": results,
 	})
 }
 
@@ -222,117 +430,196 @@ func (s *HTTPServer) handleReceiveFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := r.ParseMultipartForm(32 << 20) // 32MB max
+	peer, ok := s.checkPolicy(w, r, r.Header.Get(senderHeader))
+	if !ok {
+		return
+	}
+
+	// Files of a folder sync replace the file at their path in a sync folder
+	synced, ok := s.syncDestination(w, r, peer)
+	if !ok {
+		return
+	}
+
+	release, ok := s.acquireTransfer(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	sender := senderFromRequest(r)
+	sender.Fingerprint = peer.Fingerprint
+
+	budget, serr := s.newBudget(s.quotaKey(peer), announcedSize(r))
+	if serr != nil {
+		fmt.Printf("Refused transfer from %s (%s): %s\n", sender.Name, sender.IP, serr.reason)
+		refuseStorage(w, serr)
+		return
+	}
+	defer budget.done()
+
+	// Decode a compressed body before reading the form
+	s.throttleBody(r)
+	body, err := decodeBody(r)
 	if err != nil {
-		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		refuse(w, http.StatusUnsupportedMediaType, reasonUnsupportedEncoding, err.Error())
 		return
 	}
+	defer body.Close()
+	r.Body = body
 
-	files := r.MultipartForm.File["files"]
-	if len(files) == 0 {
-		http.Error(w, "No files received", http.StatusBadRequest)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
 		return
 	}
 
-	var savedFiles []string
+	result := receiveResult{Files: []string{}}
 
-	for _, fileHeader := range files {
-		file, err := fileHeader.Open()
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
+			http.Error(w, "Error reading upload", http.StatusBadRequest)
+			return
+		}
+		if part.FormName() != "files" || part.FileName() == "" {
 			continue
 		}
-		defer file.Close()
 
-		// Save to download directory
-		destPath := filepath.Join(s.downloadDir, fileHeader.Filename)
-		
-		// Handle duplicate filenames
-		counter := 1
-		originalPath := destPath
-		for {
-			if _, err := os.Stat(destPath); os.IsNotExist(err) {
-				break
-			}
-			ext := filepath.Ext(originalPath)
-			name := originalPath[:len(originalPath)-len(ext)]
-			destPath = fmt.Sprintf("%s_%d%s", name, counter, ext)
-			counter++
+		// Save to download directory, stopping at the first limit reached
+		destPath := s.receivePath(filepath.Base(part.FileName()))
+		if synced != nil {
+			destPath = s.stagingPath(synced)
+		}
+		n, err := budget.save(part, destPath, nil)
+		if serr, ok := err.(*storageError); ok {
+			fmt.Printf("Refused %s from %s (%s): %s\n", part.FileName(), sender.Name, sender.IP, serr.reason)
+			refuseStorage(w, serr)
+			return
 		}
-
-		dst, err := os.Create(destPath)
 		if err != nil {
+			fmt.Printf("Error saving %s: %v\n", part.FileName(), err)
 			continue
 		}
-		defer dst.Close()
 
-		_, err = io.Copy(dst, file)
-		if err != nil {
-			os.Remove(destPath)
+		fmt.Printf("Wrote %s (%d bytes)\n", destPath, n)
+		if synced == nil {
+			result.add(s.screenPeerFile(destPath, sender))
 			continue
 		}
 
-		savedFiles = append(savedFiles, filepath.Base(destPath))
-		fmt.Printf("Received file: %s\n", destPath)
+		outcome, name, err := s.saveSynced(synced, destPath, sender)
+		if err != nil {
+			fmt.Printf("Error saving %s: %v\n", synced.path, err)
+			http.Error(w, "Error saving file", http.StatusInternalServerError)
+			return
+		}
+		// The file isn't in the folder, so the sender must not count it as synced
+		if outcome == outcomeQuarantined {
+			refuse(w, http.StatusForbidden, reasonQuarantined, "This device quarantined the file")
+			return
+		}
+		result.add(outcome, name)
+		// Only one file is synced per request
+		break
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
-		"message": fmt.Sprintf("Received %d files", len(savedFiles)),
-		"files":   savedFiles,
-	})
+	if result.accepted() == 0 && len(result.Rejected) == 0 {
+		http.Error(w, "No files received", http.StatusBadRequest)
+		return
+	}
+	s.respondReceived(w, &result)
 }
 
-// sendFileToDevice sends a file to a target device
-func (s *HTTPServer) sendFileToDevice(targetIP string, targetPort int, filePath string) error {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return fmt.Errorf("failed to open file: %v", err)
-	}
-	defer file.Close()
+// uniquePath appends a counter to the file name until it doesn't exist yet
+func uniquePath(path string) string {
+	destPath := path
 
-	// Create multipart form
-	pr, pw := io.Pipe()
-	mw := NewMultipartWriter(pw)
+	// Handle duplicate filenames
+	counter := 1
+	for {
+		if _, err := os.Stat(destPath); os.IsNotExist(err) {
+			break
+		}
+		ext := filepath.Ext(path)
+		name := path[:len(path)-len(ext)]
+		destPath = fmt.Sprintf("%s_%d%s", name, counter, ext)
+		counter++
+	}
 
-	go func() {
-		defer pw.Close()
-		defer mw.Close()
+	return destPath
+}
 
-		part, err := mw.CreateFormFile("files", filepath.Base(filePath))
-		if err != nil {
-			return
-		}
+// peerURL builds the URL of an endpoint on another device. host may be an
+// IPv6 literal with a zone, which must be escaped in URLs.
+func peerURL(host string, port int, path string) string {
+	u := url.URL{
+		Scheme: "http",
+		Host:   net.JoinHostPort(host, strconv.Itoa(port)),
+		Path:   path,
+	}
+	return u.String()
+}
 
-		_, err = io.Copy(part, file)
-		if err != nil {
-			return
-		}
-	}()
+// sendFileToDevice sends a file to a target device using the best transfer
+// mode for its size, compressing it if the peer accepts that and it helps
+func (s *HTTPServer) sendFileToDevice(targetHost string, targetPort int, filePath string, mode *transferMode) (*TransferResult, error) {
+	result := &TransferResult{File: filepath.Base(filePath)}
 
-	// Send HTTP POST request
-	url := fmt.Sprintf("http://%s:%d/upload", targetIP, targetPort)
-	req, err := http.NewRequest("POST", url, pr)
+	file, err := os.Open(filePath)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %v", err)
+		return result, fmt.Errorf("failed to open file: %v", err)
 	}
+	defer file.Close()
 
-	req.Header.Set("Content-Type", mw.FormDataContentType())
+	info, err := file.Stat()
+	if err != nil {
+		return result, fmt.Errorf("failed to stat file: %v", err)
+	}
+	result.Bytes = info.Size()
 
-	client := &http.Client{
-		Timeout: 30 * time.Second,
+	if mode.Compression != "" && shouldCompress(file, info.Size()) {
+		result.Encoding = mode.Compression
 	}
 
-	resp, err := client.Do(req)
+	switch {
+	case mode.supports(discovery.CapParallel) && info.Size() >= parallelMinSize:
+		result.Mode = modeParallel
+		err = s.sendParallel(targetHost, targetPort, file, result)
+	case mode.supports(discovery.CapRaw) && result.Encoding == "":
+		result.Mode, result.Streams = modeRaw, 1
+		err = s.sendRaw(targetHost, targetPort, file, result)
+	case mode.supports(discovery.CapRaw):
+		result.Mode, result.Streams = modeRaw, 1
+		err = s.sendStream(targetHost, targetPort, file, result, true)
+	default:
+		result.Mode, result.Streams = modeMultipart, 1
+		err = s.sendStream(targetHost, targetPort, file, result, false)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to send request: %v", err)
+		return result, err
 	}
-	defer resp.Body.Close()
+	result.measure()
+
+	fmt.Printf("Successfully sent file %s to %s (%d bytes, %d on the wire, ratio %.2f, %d streams)\n",
+		result.File, net.JoinHostPort(targetHost, strconv.Itoa(targetPort)), result.Bytes, result.WireBytes, result.Ratio, result.Streams)
+	return result, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned status: %s", resp.Status)
+// sendStream sends file to /upload/raw, or as a multipart form to /upload
+// unless raw is set
+func (s *HTTPServer) sendStream(targetHost string, targetPort int, file *os.File, result *TransferResult, raw bool) error {
+	stream, err := s.newUploadStream(targetHost, targetPort, result, raw)
+	if err != nil {
+		return err
 	}
 
-	fmt.Printf("Successfully sent file %s to %s:%d\n", filepath.Base(filePath), targetIP, targetPort)
-	return nil
-}
\ No newline at end of file
+	go func() {
+		_, err := io.Copy(stream, file)
+		stream.finish(err)
+	}()
+	return stream.do(s.client)
+}
@@ -0,0 +1,370 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// uploadSessionTTL is how long an idle upload session is kept around
+const uploadSessionTTL = 10 * time.Minute
+
+// uploadSession receives one file in ranges sent over several connections
+type uploadSession struct {
+	ID       string
+	Name     string
+	Size     int64
+	IP       string // ranges are only accepted from the address that opened the session
+	path     string
+	file     *os.File
+	sender   *InboxSender
+	budget   *transferBudget
+	release  func() // frees the transfer slot
+	ranges   [][2]int64
+	writing  int  // ranges being received
+	closed   bool // completed or discarded
+	lastUsed time.Time
+	mutex    sync.Mutex
+}
+
+// record marks [start, end) as written, merging it with adjacent ranges
+func (us *uploadSession) record(start, end int64) {
+	us.ranges = append(us.ranges, [2]int64{start, end})
+	sort.Slice(us.ranges, func(i, j int) bool { return us.ranges[i][0] < us.ranges[j][0] })
+
+	merged := us.ranges[:1]
+	for _, r := range us.ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r[0] <= last[1] {
+			if r[1] > last[1] {
+				last[1] = r[1]
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	us.ranges = merged
+}
+
+// complete reports whether every byte of the file has been written
+func (us *uploadSession) complete() bool {
+	if us.Size == 0 {
+		return true
+	}
+	return len(us.ranges) == 1 && us.ranges[0][0] == 0 && us.ranges[0][1] == us.Size
+}
+
+// received returns the number of bytes written so far
+func (us *uploadSession) received() int64 {
+	var n int64
+	for _, r := range us.ranges {
+		n += r[1] - r[0]
+	}
+	return n
+}
+
+// discard closes and removes the partial file and frees the transfer slot
+// and the reserved quota
+func (us *uploadSession) discard() {
+	us.closed = true
+	us.file.Close()
+	os.Remove(us.path)
+	us.release()
+	us.budget.done()
+}
+
+// sessionStore keeps the open upload sessions in memory
+type sessionStore struct {
+	sessions map[string]*uploadSession
+	mutex    sync.Mutex
+}
+
+func newSessionStore() *sessionStore {
+	return &sessionStore{
+		sessions: make(map[string]*uploadSession),
+	}
+}
+
+// add registers a new session
+func (st *sessionStore) add(us *uploadSession) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	st.sessions[us.ID] = us
+}
+
+// prune discards the sessions that received nothing for uploadSessionTTL,
+// freeing their transfer slots
+func (st *sessionStore) prune() {
+	st.mutex.Lock()
+	open := make([]*uploadSession, 0, len(st.sessions))
+	for _, us := range st.sessions {
+		open = append(open, us)
+	}
+	st.mutex.Unlock()
+
+	now := time.Now()
+	for _, us := range open {
+		// The session is locked before the store, as in completeUpload
+		us.mutex.Lock()
+		if us.writing == 0 && now.Sub(us.lastUsed) > uploadSessionTTL && st.remove(us.ID) {
+			fmt.Printf("Discarding idle upload of %s\n", us.Name)
+			us.discard()
+		}
+		us.mutex.Unlock()
+	}
+}
+
+// get returns the session with id if it was opened from ip
+func (st *sessionStore) get(id, ip string) *uploadSession {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	us, ok := st.sessions[id]
+	if !ok || us.IP != ip {
+		return nil
+	}
+	return us
+}
+
+// remove forgets a session, reporting whether it was still open
+func (st *sessionStore) remove(id string) bool {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	_, ok := st.sessions[id]
+	delete(st.sessions, id)
+	return ok
+}
+
+// handleUploadSession opens an upload session with POST /upload/session
+func (s *HTTPServer) handleUploadSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	peer, ok := s.checkPolicy(w, r, r.Header.Get(senderHeader))
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Name string `json:"name"`
+		Size int64  `json:"size"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	name := filepath.Base(request.Name)
+	if name == "." || name == string(filepath.Separator) || request.Size < 0 {
+		http.Error(w, "Invalid file", http.StatusBadRequest)
+		return
+	}
+
+	sender := senderFromRequest(r)
+	sender.Fingerprint = peer.Fingerprint
+
+	var budget *transferBudget
+	var serr *storageError
+	if maxFile := megabytes(s.config.MaxFileSizeMB); maxFile > 0 && request.Size > maxFile {
+		serr = s.sizeError(reasonFileTooLarge)
+	} else {
+		budget, serr = s.newBudget(s.quotaKey(peer), request.Size)
+	}
+	if serr != nil {
+		fmt.Printf("Refused transfer from %s (%s): %s\n", sender.Name, sender.IP, serr.reason)
+		refuseStorage(w, serr)
+		return
+	}
+
+	release, ok := s.acquireTransfer(w, r)
+	if !ok {
+		budget.done()
+		return
+	}
+
+	id, err := newToken()
+	if err != nil {
+		release()
+		budget.done()
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+
+	path := s.receivePath(name)
+	file, err := os.Create(path)
+	if err == nil {
+		err = file.Truncate(request.Size)
+	}
+	if err != nil {
+		if file != nil {
+			file.Close()
+			os.Remove(path)
+		}
+		release()
+		budget.done()
+		http.Error(w, "Error creating file", http.StatusInternalServerError)
+		return
+	}
+
+	s.sessions.add(&uploadSession{
+		ID:       id,
+		Name:     name,
+		Size:     request.Size,
+		IP:       sender.IP,
+		path:     path,
+		file:     file,
+		sender:   sender,
+		budget:   budget,
+		release:  release,
+		lastUsed: time.Now(),
+	})
+
+	fmt.Printf("Receiving %s (%d bytes) from %s in ranges\n", name, request.Size, sender.Name)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"id":      id,
+	})
+}
+
+// handleUploadSessionRange writes a range with PUT /upload/session/{id}?offset=N,
+// finishes the file with POST /upload/session/{id}/complete and aborts it
+// with DELETE /upload/session/{id}
+func (s *HTTPServer) handleUploadSessionRange(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/upload/session/"), "/")
+
+	us := s.sessions.get(id, senderFromRequest(r).IP)
+	if us == nil {
+		http.Error(w, "Upload session not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPut && action == "":
+		s.receiveRange(w, r, us)
+	case r.Method == http.MethodPost && action == "complete":
+		s.completeUpload(w, us)
+	case r.Method == http.MethodDelete && action == "":
+		// The session is locked before the store, as in completeUpload
+		us.mutex.Lock()
+		if us.writing > 0 {
+			us.mutex.Unlock()
+			http.Error(w, "Ranges are still being received", http.StatusConflict)
+			return
+		}
+		if s.sessions.remove(us.ID) {
+			us.discard()
+		}
+		us.mutex.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// receiveRange writes the request body into the session's file at the given offset
+func (s *HTTPServer) receiveRange(w http.ResponseWriter, r *http.Request, us *uploadSession) {
+	offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil || offset < 0 || offset > us.Size {
+		http.Error(w, "Invalid offset", http.StatusBadRequest)
+		return
+	}
+
+	s.throttleBody(r)
+	body, err := decodeBody(r)
+	if err != nil {
+		refuse(w, http.StatusUnsupportedMediaType, reasonUnsupportedEncoding, err.Error())
+		return
+	}
+	defer body.Close()
+
+	us.mutex.Lock()
+	if us.closed {
+		us.mutex.Unlock()
+		http.Error(w, "Upload session not found", http.StatusNotFound)
+		return
+	}
+	us.writing++
+	us.mutex.Unlock()
+	defer func() {
+		us.mutex.Lock()
+		us.writing--
+		us.lastUsed = time.Now()
+		us.mutex.Unlock()
+	}()
+
+	// Ranges are written concurrently; WriteAt is safe for that
+	n, err := io.Copy(io.NewOffsetWriter(us.file, offset), io.LimitReader(body, us.Size-offset))
+	if err == nil {
+		if extra, _ := io.ReadFull(body, make([]byte, 1)); extra > 0 {
+			http.Error(w, "Range extends past the end of the file", http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+	}
+	if err != nil {
+		http.Error(w, "Error writing range", http.StatusBadRequest)
+		return
+	}
+
+	us.mutex.Lock()
+	us.record(offset, offset+n)
+	received := us.received()
+	us.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"received": received,
+	})
+}
+
+// completeUpload checks that every range arrived and hands the file to screening
+func (s *HTTPServer) completeUpload(w http.ResponseWriter, us *uploadSession) {
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	// A range sent twice may still be writing over bytes already recorded
+	if us.writing > 0 {
+		http.Error(w, "Ranges are still being received", http.StatusConflict)
+		return
+	}
+	if !us.complete() {
+		http.Error(w, fmt.Sprintf("Missing ranges: received %d of %d bytes", us.received(), us.Size), http.StatusConflict)
+		return
+	}
+	if !s.sessions.remove(us.ID) {
+		http.Error(w, "Upload session not found", http.StatusNotFound)
+		return
+	}
+
+	us.closed = true
+	err := us.file.Close()
+	us.release()
+	if err != nil {
+		us.budget.done()
+		os.Remove(us.path)
+		http.Error(w, "Error saving file", http.StatusInternalServerError)
+		return
+	}
+	us.budget.spent(us.Size)
+	us.budget.done()
+
+	fmt.Printf("Wrote %s (%d bytes)\n", us.path, us.Size)
+	result := receiveResult{Files: []string{}}
+	result.add(s.screenPeerFile(us.path, us.sender))
+
+	s.respondReceived(w, &result)
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUploadSessionRecord(t *testing.T) {
+	tests := []struct {
+		name     string
+		size     int64
+		ranges   [][2]int64 // recorded in this order
+		want     [][2]int64
+		complete bool
+	}{
+		{
+			name:     "single range",
+			size:     100,
+			ranges:   [][2]int64{{0, 100}},
+			want:     [][2]int64{{0, 100}},
+			complete: true,
+		},
+		{
+			name:     "adjacent in order",
+			size:     100,
+			ranges:   [][2]int64{{0, 40}, {40, 70}, {70, 100}},
+			want:     [][2]int64{{0, 100}},
+			complete: true,
+		},
+		{
+			name:     "out of order",
+			size:     100,
+			ranges:   [][2]int64{{70, 100}, {0, 40}, {40, 70}},
+			want:     [][2]int64{{0, 100}},
+			complete: true,
+		},
+		{
+			name:     "overlapping",
+			size:     100,
+			ranges:   [][2]int64{{0, 60}, {50, 100}},
+			want:     [][2]int64{{0, 100}},
+			complete: true,
+		},
+		{
+			name:     "contained in another",
+			size:     100,
+			ranges:   [][2]int64{{0, 100}, {20, 30}},
+			want:     [][2]int64{{0, 100}},
+			complete: true,
+		},
+		{
+			name:     "sent twice",
+			size:     100,
+			ranges:   [][2]int64{{50, 100}, {50, 100}, {0, 50}},
+			want:     [][2]int64{{0, 100}},
+			complete: true,
+		},
+		{
+			name:     "gap",
+			size:     100,
+			ranges:   [][2]int64{{60, 100}, {0, 40}},
+			want:     [][2]int64{{0, 40}, {60, 100}},
+			complete: false,
+		},
+		{
+			name:     "gap filled last",
+			size:     100,
+			ranges:   [][2]int64{{60, 100}, {0, 40}, {30, 70}},
+			want:     [][2]int64{{0, 100}},
+			complete: true,
+		},
+		{
+			name:     "missing start",
+			size:     100,
+			ranges:   [][2]int64{{50, 100}, {10, 50}},
+			want:     [][2]int64{{10, 100}},
+			complete: false,
+		},
+		{
+			name:     "missing end",
+			size:     100,
+			ranges:   [][2]int64{{40, 90}, {0, 40}},
+			want:     [][2]int64{{0, 90}},
+			complete: false,
+		},
+	}
+
+	for _, tt := range tests {
+		us := &uploadSession{Size: tt.size}
+		for _, r := range tt.ranges {
+			us.record(r[0], r[1])
+		}
+		if !reflect.DeepEqual(us.ranges, tt.want) {
+			t.Errorf("%s: ranges = %v, want %v", tt.name, us.ranges, tt.want)
+		}
+		if got := us.complete(); got != tt.complete {
+			t.Errorf("%s: complete() = %v, want %v", tt.name, got, tt.complete)
+		}
+	}
+}
+
+func TestUploadSessionEmpty(t *testing.T) {
+	if !(&uploadSession{}).complete() {
+		t.Error("complete() = false for an empty file")
+	}
+	if (&uploadSession{Size: 1}).complete() {
+		t.Error("complete() = true with nothing written")
+	}
+}
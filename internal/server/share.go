@@ -0,0 +1,432 @@
+package server
+
+import (
+	"archive/zip"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"localsend/internal/qrcode"
+)
+
+// defaultShareExpiry is used when a share request doesn't specify one
+const defaultShareExpiry = 60 * time.Minute
+
+// resumeCookie lets the browser that downloaded a shared file resume it
+// without counting another download
+const resumeCookie = "localsend_resume"
+
+// sharePage renders the public download page of a share link
+var sharePage = template.Must(template.New("share").Funcs(template.FuncMap{
+	"size": formatSize,
+}).Parse(shareHTML))
+
+// SharedFile is a file offered through a share link
+type SharedFile struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	path string
+}
+
+// Share is a set of files hosted at a tokenized URL for browsers on the LAN
+type Share struct {
+	Token        string         `json:"token"`
+	URL          string         `json:"url"`
+	Files        []*SharedFile  `json:"files"`
+	CreatedAt    time.Time      `json:"createdAt"`
+	ExpiresAt    time.Time      `json:"expiresAt"`
+	MaxDownloads int            `json:"maxDownloads"`
+	Downloads    int            `json:"downloads"`
+	resumes      map[string]int // resume cookie -> index of the file downloaded
+}
+
+// expired reports whether the share can no longer be downloaded
+func (sh *Share) expired(now time.Time) bool {
+	if now.After(sh.ExpiresAt) {
+		return true
+	}
+	return sh.MaxDownloads > 0 && sh.Downloads >= sh.MaxDownloads
+}
+
+// shareStore keeps the active share links in memory
+type shareStore struct {
+	shares map[string]*Share
+	mutex  sync.Mutex
+}
+
+func newShareStore() *shareStore {
+	return &shareStore{
+		shares: make(map[string]*Share),
+	}
+}
+
+// add registers a new share
+func (st *shareStore) add(sh *Share) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	st.shares[sh.Token] = sh
+}
+
+// get returns an active share, dropping it if it has expired
+func (st *shareStore) get(token string) *Share {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	sh, ok := st.shares[token]
+	if !ok {
+		return nil
+	}
+	if sh.expired(time.Now()) {
+		delete(st.shares, token)
+		return nil
+	}
+	return sh
+}
+
+// countDownload records a download, failing if the limit has been reached
+func (st *shareStore) countDownload(token string) bool {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	sh, ok := st.shares[token]
+	if !ok || sh.expired(time.Now()) {
+		return false
+	}
+	sh.Downloads++
+	return true
+}
+
+// grantResume returns a cookie value that lets a counted download of the
+// file at index be resumed
+func (st *shareStore) grantResume(token string, index int) string {
+	value, err := newToken()
+	if err != nil {
+		return ""
+	}
+
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	sh, ok := st.shares[token]
+	if !ok {
+		return ""
+	}
+	if sh.resumes == nil {
+		sh.resumes = make(map[string]int)
+	}
+	sh.resumes[value] = index
+	return value
+}
+
+// canResume reports whether the cookie value was granted for the file at index
+func (st *shareStore) canResume(token string, index int, value string) bool {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	sh, ok := st.shares[token]
+	if !ok || value == "" {
+		return false
+	}
+	granted, ok := sh.resumes[value]
+	return ok && granted == index
+}
+
+// remove deletes a share
+func (st *shareStore) remove(token string) bool {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	if _, ok := st.shares[token]; !ok {
+		return false
+	}
+	delete(st.shares, token)
+	return true
+}
+
+// list returns copies of the active shares, pruning expired ones
+func (st *shareStore) list() []*Share {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	now := time.Now()
+	shares := make([]*Share, 0, len(st.shares))
+	for token, sh := range st.shares {
+		if sh.expired(now) {
+			delete(st.shares, token)
+			continue
+		}
+		snapshot := *sh
+		snapshot.resumes = nil
+		shares = append(shares, &snapshot)
+	}
+	return shares
+}
+
+// newToken returns a random URL-safe token
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// handleShares lists existing share links or creates a new one
+func (s *HTTPServer) handleShares(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"shares":  s.shares.list(),
+		})
+
+	case http.MethodPost:
+		s.createShare(w, r)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// createShare hosts files uploaded through /api/upload at a new share link
+func (s *HTTPServer) createShare(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		FilePaths    []string `json:"filePaths"`
+		ExpiresIn    int      `json:"expiresIn"` // minutes
+		MaxDownloads int      `json:"maxDownloads"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if len(request.FilePaths) == 0 {
+		http.Error(w, "No files to share", http.StatusBadRequest)
+		return
+	}
+
+	var files []*SharedFile
+	for _, path := range request.FilePaths {
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			http.Error(w, fmt.Sprintf("Cannot share %s", filepath.Base(path)), http.StatusBadRequest)
+			return
+		}
+		files = append(files, &SharedFile{
+			Name: filepath.Base(path),
+			Size: info.Size(),
+			path: path,
+		})
+	}
+
+	token, err := newToken()
+	if err != nil {
+		http.Error(w, "Failed to create share token", http.StatusInternalServerError)
+		return
+	}
+
+	expiry := defaultShareExpiry
+	if request.ExpiresIn > 0 {
+		expiry = time.Duration(request.ExpiresIn) * time.Minute
+	}
+
+	now := time.Now()
+	sh := &Share{
+		Token:        token,
+		URL:          fmt.Sprintf("http://%s:%d/s/%s", s.discoveryService.LocalIP(), s.port, token),
+		Files:        files,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(expiry),
+		MaxDownloads: request.MaxDownloads,
+	}
+	s.shares.add(sh)
+
+	fmt.Printf("Created share %s with %d files\n", sh.URL, len(files))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"share":   sh,
+	})
+}
+
+// handleShare serves the QR code of a share link or revokes it
+func (s *HTTPServer) handleShare(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/shares/")
+	token, action, _ := strings.Cut(rest, "/")
+
+	switch {
+	case action == "qr" && r.Method == http.MethodGet:
+		sh := s.shares.get(token)
+		if sh == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		code, err := qrcode.Encode(sh.URL)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write([]byte(code.SVG()))
+
+	case action == "" && r.Method == http.MethodDelete:
+		if !s.shares.remove(token) {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handlePublicShare serves the download page, single files and the zip of a share
+func (s *HTTPServer) handlePublicShare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rest := strings.TrimPrefix(r.URL.Path, "/s/")
+	token, item, _ := strings.Cut(rest, "/")
+
+	sh := s.shares.get(token)
+	if sh == nil {
+		s.authFailed(r, "unknown share link")
+		http.Error(w, "This link has expired or does not exist", http.StatusGone)
+		return
+	}
+
+	switch item {
+	case "":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		sharePage.Execute(w, sh)
+
+	case "zip":
+		if !s.shares.countDownload(token) {
+			http.Error(w, "Download limit reached", http.StatusGone)
+			return
+		}
+		s.streamShareZip(s.throttleResponse(w, r), sh)
+
+	default:
+		index, err := strconv.Atoi(item)
+		if err != nil || index < 0 || index >= len(sh.Files) {
+			http.NotFound(w, r)
+			return
+		}
+		file := sh.Files[index]
+
+		f, err := os.Open(file.path)
+		if err != nil {
+			http.Error(w, "File is no longer available", http.StatusGone)
+			return
+		}
+		defer f.Close()
+
+		// Resuming a counted download doesn't count again; any other request,
+		// including suffix and multi-part ranges, does
+		resumed := false
+		if cookie, err := r.Cookie(resumeCookie); err == nil && isResume(r.Header.Get("Range")) {
+			resumed = s.shares.canResume(token, index, cookie.Value)
+		}
+		if !resumed {
+			if !s.shares.countDownload(token) {
+				http.Error(w, "Download limit reached", http.StatusGone)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{
+				Name:     resumeCookie,
+				Value:    s.shares.grantResume(token, index),
+				Path:     r.URL.Path,
+				HttpOnly: true,
+				SameSite: http.SameSiteStrictMode,
+			})
+		}
+
+		w.Header().Set("Content-Disposition", contentDisposition(file.Name))
+		http.ServeContent(s.throttleResponse(w, r), r, file.Name, time.Time{}, f)
+	}
+}
+
+// isResume reports whether a Range header asks for one range that starts
+// past the beginning of the file, as when a browser resumes a download
+func isResume(header string) bool {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return false
+	}
+	start, _, _ := strings.Cut(spec, "-")
+	n, err := strconv.ParseInt(strings.TrimSpace(start), 10, 64)
+	return err == nil && n > 0
+}
+
+// streamShareZip writes all files of a share as a zip archive without buffering
+func (s *HTTPServer) streamShareZip(w http.ResponseWriter, sh *Share) {
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", contentDisposition("localsend-"+sh.Token[:8]+".zip"))
+
+	zw := zip.NewWriter(w)
+	defer zw.Close()
+
+	for _, file := range sh.Files {
+		f, err := os.Open(file.path)
+		if err != nil {
+			fmt.Printf("Error opening shared file %s: %v\n", file.path, err)
+			continue
+		}
+
+		header := &zip.FileHeader{
+			Name:     file.Name,
+			Method:   zip.Store, // LAN bandwidth is cheaper than CPU here
+			Modified: time.Now(),
+		}
+		part, err := zw.CreateHeader(header)
+		if err != nil {
+			f.Close()
+			return
+		}
+
+		_, err = io.Copy(part, f)
+		f.Close()
+		if err != nil {
+			fmt.Printf("Error streaming shared file %s: %v\n", file.path, err)
+			return
+		}
+	}
+}
+
+// formatSize renders a byte count for humans
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"localsend/internal/policy"
+)
+
+// totalSizeHeader announces the total size of the files in an upload
+const totalSizeHeader = "X-LocalSend-Total-Size"
+
+// peerQuotaWindow is the rolling window of the per-peer quota
+const peerQuotaWindow = 24 * time.Hour
+
+// Reasons a transfer is refused for its size
+const (
+	reasonInsufficientStorage = "insufficient_storage"
+	reasonQuotaExceeded       = "quota_exceeded"
+	reasonFileTooLarge        = "file_too_large"
+	reasonBatchTooLarge       = "batch_too_large"
+)
+
+// storageError refuses a transfer that doesn't fit
+type storageError struct {
+	status  int
+	reason  string
+	message string
+}
+
+func (e *storageError) Error() string {
+	return e.message
+}
+
+// refuseStorage answers a refused transfer with its reason
+func refuseStorage(w http.ResponseWriter, err *storageError) {
+	refuse(w, err.status, err.reason, err.message)
+}
+
+// megabytes converts a configured size in MB to bytes
+func megabytes(mb int) int64 {
+	return int64(mb) << 20
+}
+
+// announcedSize returns the size the sender says it will upload, or 0 if unknown
+func announcedSize(r *http.Request) int64 {
+	if size, err := strconv.ParseInt(r.Header.Get(totalSizeHeader), 10, 64); err == nil && size > 0 {
+		return size
+	}
+	if r.ContentLength > 0 {
+		return r.ContentLength
+	}
+	return 0
+}
+
+// transferBudget bounds what one incoming transfer may still write
+type transferBudget struct {
+	server    *HTTPServer
+	peer      string // quota key, see quotaKey
+	batchLeft int64  // -1 without a batch limit
+	reserved  int64  // quota set aside for the announced size and not spent yet
+}
+
+// quotaKey identifies a peer for its quota: paired devices by their
+// fingerprint, others by their IP, since anyone can sign with a new key
+func (s *HTTPServer) quotaKey(peer policy.Peer) string {
+	if peer.Fingerprint != "" && s.discoveryService.IsTrusted(peer.Fingerprint) {
+		return peer.Fingerprint
+	}
+	return peer.IP
+}
+
+// newBudget checks an announced transfer against the size limits, the free
+// space in the download directory and the peer's quota, and reserves the
+// announced size in the quota until done is called. A total of 0 means the
+// size is unknown and only enforced while writing.
+func (s *HTTPServer) newBudget(peer string, total int64) (*transferBudget, *storageError) {
+	b := &transferBudget{server: s, peer: peer, batchLeft: -1}
+	if maxBatch := megabytes(s.config.MaxBatchSizeMB); maxBatch > 0 {
+		b.batchLeft = maxBatch
+	}
+
+	if total > 0 {
+		if b.batchLeft >= 0 && total > b.batchLeft {
+			return nil, b.server.sizeError(reasonBatchTooLarge)
+		}
+		if !s.quota.Reserve(peer, total) {
+			return nil, b.server.sizeError(reasonQuotaExceeded)
+		}
+		b.reserved = total
+	}
+
+	if room, ok := b.room(); ok && (room <= 0 || total > room) {
+		b.done()
+		return nil, b.server.sizeError(reasonInsufficientStorage)
+	}
+	return b, nil
+}
+
+// done releases the part of the reserved quota the transfer didn't use
+func (b *transferBudget) done() {
+	b.server.quota.Release(b.peer, b.reserved)
+	b.reserved = 0
+}
+
+// room returns the bytes that can be written before the disk reaches the
+// configured minimum free space, and false if the free space is unknown
+func (b *transferBudget) room() (int64, bool) {
+	free, err := diskFree(b.server.downloadDir)
+	if err != nil {
+		return 0, false
+	}
+	return free - megabytes(b.server.config.MinFreeSpaceMB), true
+}
+
+// limit returns the largest next file that fits, with the error to report
+// if it turns out bigger, or -1 without any limit
+func (b *transferBudget) limit() (int64, *storageError) {
+	limit, reason := int64(-1), ""
+	consider := func(n int64, r string) {
+		if n >= 0 && (limit < 0 || n < limit) {
+			limit, reason = n, r
+		}
+	}
+
+	if maxFile := megabytes(b.server.config.MaxFileSizeMB); maxFile > 0 {
+		consider(maxFile, reasonFileTooLarge)
+	}
+	consider(b.batchLeft, reasonBatchTooLarge)
+	if quota := b.server.quota.Remaining(b.peer); quota >= 0 {
+		consider(quota+b.reserved, reasonQuotaExceeded)
+	}
+	if room, ok := b.room(); ok {
+		if room < 0 {
+			room = 0
+		}
+		consider(room, reasonInsufficientStorage)
+	}
+
+	if limit < 0 {
+		return -1, nil
+	}
+	return limit, b.server.sizeError(reason)
+}
+
+// save writes one file of the transfer within the remaining budget
+func (b *transferBudget) save(src io.Reader, destPath string, progress *int64) (int64, error) {
+	limit, tooLarge := b.limit()
+
+	var n int64
+	var err error
+	if tooLarge == nil {
+		n, err = saveFile(src, destPath, -1, nil, progress)
+	} else {
+		n, err = saveFile(src, destPath, limit, tooLarge, progress)
+	}
+	if err == nil {
+		b.spent(n)
+	}
+	return n, err
+}
+
+// spent records n bytes kept by the transfer
+func (b *transferBudget) spent(n int64) {
+	if b.batchLeft >= 0 {
+		b.batchLeft -= n
+	}
+	b.server.quota.Add(b.peer, n)
+
+	release := n
+	if release > b.reserved {
+		release = b.reserved
+	}
+	b.server.quota.Release(b.peer, release)
+	b.reserved -= release
+}
+
+// sizeError builds the error for a size limit that was hit
+func (s *HTTPServer) sizeError(reason string) *storageError {
+	cfg := s.config
+	switch reason {
+	case reasonFileTooLarge:
+		return &storageError{http.StatusRequestEntityTooLarge, reason, fmt.Sprintf("Files larger than %d MB are not accepted", cfg.MaxFileSizeMB)}
+	case reasonBatchTooLarge:
+		return &storageError{http.StatusRequestEntityTooLarge, reason, fmt.Sprintf("Transfers larger than %d MB are not accepted", cfg.MaxBatchSizeMB)}
+	case reasonQuotaExceeded:
+		return &storageError{http.StatusInsufficientStorage, reason, fmt.Sprintf("Transfer exceeds your quota of %d MB per day", cfg.PeerQuotaMB)}
+	default:
+		return &storageError{http.StatusInsufficientStorage, reasonInsufficientStorage, "Not enough free disk space on the receiving device"}
+	}
+}
+
+// saveFile writes src to destPath, failing with tooLarge and removing the
+// partial file once more than limit bytes arrive. A limit below 0 means no
+// limit. progress, if not nil, is updated as bytes are written.
+func saveFile(src io.Reader, destPath string, limit int64, tooLarge error, progress *int64) (int64, error) {
+	dst, err := os.Create(destPath)
+	if err != nil {
+		return 0, err
+	}
+
+	if limit >= 0 {
+		src = io.LimitReader(src, limit+1)
+	}
+	if progress != nil {
+		src = &progressReader{reader: src, progress: progress}
+	}
+
+	n, err := copyFile(dst, src)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && limit >= 0 && n > limit {
+		err = tooLarge
+	}
+	if err != nil {
+		os.Remove(destPath)
+	}
+	return n, err
+}
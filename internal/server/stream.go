@@ -0,0 +1,147 @@
+package server
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+)
+
+// uploadStream is an upload to one peer whose body is written by the caller,
+// through the peer's compression and the upload limits
+type uploadStream struct {
+	req   *http.Request
+	pr    *io.PipeReader
+	pw    *io.PipeWriter
+	body  io.Writer        // compressed, throttled and counted
+	mw    *MultipartWriter // nil for raw uploads
+	gz    *gzip.Writer     // nil without compression
+	sink  io.Writer        // where the file's bytes go once started
+	name  string
+	err   error // first failure
+	mutex sync.Mutex
+}
+
+// newUploadStream prepares sending the file described by result to
+// /upload/raw, or to /upload as a multipart form unless raw is set
+func (s *HTTPServer) newUploadStream(targetHost string, targetPort int, result *TransferResult, raw bool) (*uploadStream, error) {
+	pr, pw := io.Pipe()
+	us := &uploadStream{pr: pr, pw: pw, name: result.File}
+	us.body = &countingWriter{writer: s.uploads.Writer(targetHost, pw), count: &result.WireBytes}
+	if result.Encoding == encodingGzip {
+		us.gz = gzip.NewWriter(us.body)
+		us.body = us.gz
+	}
+
+	path, contentType := "/upload/raw", "application/octet-stream"
+	if !raw {
+		us.mw = NewMultipartWriter(us.body)
+		path, contentType = "/upload", us.mw.FormDataContentType()
+	}
+
+	req, err := http.NewRequest(http.MethodPost, peerURL(targetHost, targetPort, path), pr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(senderHeader, s.discoveryService.DeviceName())
+	req.Header.Set(totalSizeHeader, strconv.FormatInt(result.Bytes, 10))
+	if raw {
+		req.Header.Set(fileNameHeader, url.PathEscape(result.File))
+	}
+	if result.Encoding != "" {
+		req.Header.Set("Content-Encoding", result.Encoding)
+	} else if raw {
+		req.ContentLength = result.Bytes
+	}
+	s.discoveryService.SignRequest(req, s.discoveryService.DeviceName())
+
+	us.req = req
+	return us, nil
+}
+
+// start writes what precedes the file's bytes, once the request is running
+func (us *uploadStream) start() error {
+	if us.sink != nil {
+		return nil
+	}
+	if us.mw == nil {
+		us.sink = us.body
+		return nil
+	}
+
+	part, err := us.mw.CreateFormFile("files", us.name)
+	if err != nil {
+		return err
+	}
+	us.sink = part
+	return nil
+}
+
+// Write sends bytes of the file
+func (us *uploadStream) Write(p []byte) (int, error) {
+	if err := us.start(); err != nil {
+		return 0, err
+	}
+	return us.sink.Write(p)
+}
+
+// finish completes the body, or aborts the upload if err is set
+func (us *uploadStream) finish(err error) {
+	if err == nil {
+		err = us.start()
+	}
+	if err == nil && us.mw != nil {
+		err = us.mw.Close()
+	}
+	if err == nil && us.gz != nil {
+		err = us.gz.Close()
+	}
+	if err != nil {
+		us.fail(err)
+		return
+	}
+	us.pw.Close()
+}
+
+// fail aborts the upload, unblocking pending writes and the request
+func (us *uploadStream) fail(err error) {
+	us.mutex.Lock()
+	if us.err == nil {
+		us.err = err
+	}
+	us.mutex.Unlock()
+
+	us.pw.CloseWithError(err)
+	us.pr.CloseWithError(err)
+}
+
+// failed reports whether the upload was aborted
+func (us *uploadStream) failed() bool {
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	return us.err != nil
+}
+
+// do sends the request and waits for the peer's answer
+func (us *uploadStream) do(client *http.Client) error {
+	resp, err := client.Do(us.req)
+	if err != nil {
+		err = fmt.Errorf("failed to send request: %v", err)
+		us.fail(err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err = refusal(resp)
+		us.fail(err)
+		return err
+	}
+	return nil
+}
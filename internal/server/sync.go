@@ -0,0 +1,635 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"localsend/internal/config"
+	"localsend/internal/discovery"
+	"localsend/internal/policy"
+	"localsend/internal/quarantine"
+)
+
+// Headers of a file sent to /upload into a sync folder
+const (
+	syncFolderHeader = "X-LocalSend-Sync-Folder"
+	syncPathHeader   = "X-LocalSend-Sync-Path" // path-escaped, relative to the folder
+	modTimeHeader    = "X-LocalSend-Modified"  // RFC 3339
+)
+
+// syncTempPrefix names files still being received into a sync folder. Like
+// other hidden files, they are left out of manifests.
+const syncTempPrefix = ".localsend-sync-"
+
+// reasonUnknownFolder refuses a sync into a folder that isn't configured
+const reasonUnknownFolder = "unknown_folder"
+
+// maxManifestSize limits the manifest a peer may send
+const maxManifestSize = 64 << 20
+
+// A sync request a peer turns away with 429 is tried syncAttempts times,
+// waiting as long as the peer asks but at most maxSyncRetryWait
+const (
+	syncAttempts     = 5
+	maxSyncRetryWait = 30 * time.Second
+)
+
+// SyncFile is an entry of the manifest of a synced folder
+type SyncFile struct {
+	Path    string    `json:"path"` // relative to the folder, with forward slashes
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Hash    string    `json:"hash,omitempty"` // SHA-256
+}
+
+// SyncResult is the outcome of syncing a folder to a peer
+type SyncResult struct {
+	Success   bool     `json:"success"`
+	Uploaded  []string `json:"uploaded"`
+	Deleted   []string `json:"deleted"`
+	Unchanged int      `json:"unchanged"`
+	Errors    []string `json:"errors"`
+}
+
+// syncRefusals remembers the files peers refused to take into a sync
+// folder for their type, so that they aren't sent again until they change
+type syncRefusals struct {
+	refused map[string]string // refusalKey -> error
+	mutex   sync.Mutex
+}
+
+// refusalKey identifies a version of a file synced to a peer's folder
+func refusalKey(target, folder string, file SyncFile) string {
+	return strings.Join([]string{target, folder, file.Path, file.Hash}, "\x00")
+}
+
+// get returns why the peer refused the file, if it did
+func (sr *syncRefusals) get(key string) (string, bool) {
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+
+	message, ok := sr.refused[key]
+	return message, ok
+}
+
+// add records that the peer refused the file for good
+func (sr *syncRefusals) add(key, message string) {
+	sr.mutex.Lock()
+	defer sr.mutex.Unlock()
+
+	sr.refused[key] = message
+}
+
+// retryBusy calls fn until it succeeds, fails other than with a *busyError,
+// or has been tried syncAttempts times
+func retryBusy(fn func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		busy, ok := err.(*busyError)
+		if !ok || attempt == syncAttempts {
+			return err
+		}
+		wait := busy.RetryAfter
+		if wait > maxSyncRetryWait {
+			wait = maxSyncRetryWait
+		}
+		time.Sleep(wait)
+	}
+}
+
+// hashCache keeps the hashes of files so unchanged files aren't read again
+type hashCache struct {
+	entries map[string]SyncFile // by absolute path
+	mutex   sync.Mutex
+}
+
+func newHashCache() *hashCache {
+	return &hashCache{entries: make(map[string]SyncFile)}
+}
+
+// hash returns the SHA-256 of the file at path, which has the given size
+// and modification time
+func (hc *hashCache) hash(path string, size int64, modTime time.Time) (string, error) {
+	hc.mutex.Lock()
+	cached, ok := hc.entries[path]
+	hc.mutex.Unlock()
+	if ok && cached.Size == size && cached.ModTime.Equal(modTime) {
+		return cached.Hash, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	hc.mutex.Lock()
+	hc.entries[path] = SyncFile{Size: size, ModTime: modTime, Hash: sum}
+	hc.mutex.Unlock()
+	return sum, nil
+}
+
+// listSyncFolder returns the regular files below root without their hashes,
+// skipping hidden files and folders
+func listSyncFolder(root string) ([]SyncFile, error) {
+	files := []SyncFile{}
+	err := filepath.WalkDir(root, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == root {
+			return nil
+		}
+		if strings.HasPrefix(entry.Name(), ".") {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, SyncFile{
+			Path:    filepath.ToSlash(rel),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+		return nil
+	})
+	return files, err
+}
+
+// cleanSyncPath turns a relative path from a peer into a path below the
+// sync folder, refusing paths that leave it or name hidden files
+func cleanSyncPath(p string) (string, bool) {
+	clean := path.Clean("/" + p)
+	if clean == "/" {
+		return "", false
+	}
+	for _, part := range strings.Split(clean[1:], "/") {
+		if strings.HasPrefix(part, ".") || strings.Contains(part, `\`) {
+			return "", false
+		}
+	}
+	return filepath.FromSlash(clean[1:]), true
+}
+
+// removeEmptyDirs removes dir and its parents below root while they are empty
+func removeEmptyDirs(root, dir string) {
+	for dir != root && strings.HasPrefix(dir, root) {
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// syncFolder returns the sync folder called name if the peer may sync into
+// it, and answers the request otherwise
+func (s *HTTPServer) syncFolder(w http.ResponseWriter, name string, peer policy.Peer) (config.SyncFolder, bool) {
+	folder, ok := s.config.FindSyncFolder(name)
+	if !ok {
+		refuse(w, http.StatusNotFound, reasonUnknownFolder, fmt.Sprintf("There is no sync folder called %q", name))
+		return folder, false
+	}
+	if !folder.Accepts(peer.Fingerprint, s.discoveryService.IsTrusted(peer.Fingerprint)) {
+		fmt.Printf("Refused sync into %s from %s (%s)\n", folder.Name, peer.Name, peer.IP)
+		message := "This device does not accept syncing into this folder from you"
+		if peer.Fingerprint == "" {
+			message = "Syncing requires a signed request"
+		}
+		refuse(w, http.StatusForbidden, policy.ReasonNotAllowed, message)
+		return folder, false
+	}
+	if err := os.MkdirAll(folder.Path, 0755); err != nil {
+		fmt.Printf("Error creating sync folder %s: %v\n", folder.Path, err)
+		http.Error(w, "Error creating sync folder", http.StatusInternalServerError)
+		return folder, false
+	}
+	return folder, true
+}
+
+// handleSyncManifest compares the manifest of a folder on another device
+// with the sync folder it names. It answers with the files the sender has to
+// upload and, if the sender asks for it, deletes files the sender doesn't have.
+func (s *HTTPServer) handleSyncManifest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	peer, ok := s.checkPolicy(w, r, r.Header.Get(senderHeader))
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Folder string     `json:"folder"`
+		Files  []SyncFile `json:"files"`
+		Delete bool       `json:"delete"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxManifestSize)
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	folder, ok := s.syncFolder(w, request.Folder, peer)
+	if !ok {
+		return
+	}
+
+	local, err := listSyncFolder(folder.Path)
+	if err != nil {
+		fmt.Printf("Error reading sync folder %s: %v\n", folder.Path, err)
+		http.Error(w, "Error reading sync folder", http.StatusInternalServerError)
+		return
+	}
+	have := make(map[string]SyncFile, len(local))
+	for _, file := range local {
+		have[file.Path] = file
+	}
+
+	needed := []string{}
+	wanted := make(map[string]bool, len(request.Files))
+	for _, file := range request.Files {
+		rel, ok := cleanSyncPath(file.Path)
+		if !ok {
+			http.Error(w, fmt.Sprintf("Invalid path %q", file.Path), http.StatusBadRequest)
+			return
+		}
+		file.Path = filepath.ToSlash(rel)
+		wanted[file.Path] = true
+
+		existing, ok := have[file.Path]
+		if !ok || !s.sameSyncFile(filepath.Join(folder.Path, rel), existing, file) {
+			needed = append(needed, file.Path)
+		}
+	}
+
+	deleted := []string{}
+	if request.Delete {
+		for _, file := range local {
+			if wanted[file.Path] {
+				continue
+			}
+			p := filepath.Join(folder.Path, filepath.FromSlash(file.Path))
+			if err := os.Remove(p); err != nil {
+				fmt.Printf("Error deleting %s: %v\n", p, err)
+				continue
+			}
+			removeEmptyDirs(folder.Path, filepath.Dir(p))
+			deleted = append(deleted, file.Path)
+		}
+	}
+
+	if len(needed) > 0 || len(deleted) > 0 {
+		fmt.Printf("Sync of %s from %s (%s): %d files to receive, %d deleted\n", folder.Name, peer.Name, peer.IP, len(needed), len(deleted))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"needed":  needed,
+		"deleted": deleted,
+	})
+}
+
+// sameSyncFile reports whether the local file at p already has the content
+// of want. Files of the same size whose modification time differs are
+// compared by hash, and get the sender's time if they match.
+func (s *HTTPServer) sameSyncFile(p string, have, want SyncFile) bool {
+	if have.Size != want.Size {
+		return false
+	}
+	if have.ModTime.Equal(want.ModTime) {
+		return true
+	}
+	hash, err := s.hashes.hash(p, have.Size, have.ModTime)
+	if err != nil || hash != want.Hash {
+		return false
+	}
+	os.Chtimes(p, want.ModTime, want.ModTime)
+	return true
+}
+
+// syncUpload is a file received into a sync folder
+type syncUpload struct {
+	folder  config.SyncFolder
+	path    string // where the file goes
+	rel     string // path relative to the folder, with forward slashes
+	modTime time.Time
+}
+
+// syncDestination returns where an upload to /upload goes if it is part of
+// a folder sync, or nil for ordinary uploads. It answers requests that can't
+// be accepted.
+func (s *HTTPServer) syncDestination(w http.ResponseWriter, r *http.Request, peer policy.Peer) (*syncUpload, bool) {
+	name := r.Header.Get(syncFolderHeader)
+	if name == "" {
+		return nil, true
+	}
+
+	rel, err := url.PathUnescape(r.Header.Get(syncPathHeader))
+	clean, ok := cleanSyncPath(rel)
+	if err != nil || !ok {
+		http.Error(w, "Invalid sync path", http.StatusBadRequest)
+		return nil, false
+	}
+
+	folder, ok := s.syncFolder(w, name, peer)
+	if !ok {
+		return nil, false
+	}
+
+	upload := &syncUpload{
+		folder: folder,
+		path:   filepath.Join(folder.Path, clean),
+		rel:    filepath.ToSlash(clean),
+	}
+	upload.modTime, _ = time.Parse(time.RFC3339Nano, r.Header.Get(modTimeHeader))
+	return upload, true
+}
+
+// stagingPath returns where the upload is written before it replaces the
+// file in the sync folder
+func (s *HTTPServer) stagingPath(upload *syncUpload) string {
+	if s.screening() {
+		return s.receivePath(filepath.Base(upload.path))
+	}
+	dir := filepath.Dir(upload.path)
+	os.MkdirAll(dir, 0755)
+	return uniquePath(filepath.Join(dir, syncTempPrefix+filepath.Base(upload.path)))
+}
+
+// saveSynced screens a file received for a sync folder and moves it into
+// place. Unlike other uploads, the scan is waited for and an existing file
+// is replaced.
+func (s *HTTPServer) saveSynced(upload *syncUpload, staged string, sender *InboxSender) (string, string, error) {
+	if s.screening() {
+		action, pattern := s.fileRules.Classify(filepath.Base(upload.path), sniff(staged))
+		switch action {
+		case quarantine.Deny:
+			os.Remove(staged)
+			fmt.Printf("Rejected synced file %s (%s)\n", upload.rel, pattern)
+			return outcomeRejected, upload.rel, nil
+		case quarantine.Quarantine:
+			return outcomeQuarantined, s.quarantineFile(staged, sender, "file type "+pattern), nil
+		}
+
+		if s.scanner != nil {
+			result, err := s.scanner.Scan(context.Background(), staged)
+			switch {
+			case err != nil:
+				return outcomeQuarantined, s.quarantineFile(staged, sender, err.Error()), nil
+			case !result.Clean:
+				return outcomeQuarantined, s.quarantineFile(staged, sender, "scanner: "+result.Detail), nil
+			}
+		}
+	}
+
+	os.MkdirAll(filepath.Dir(upload.path), 0755)
+	if err := os.Rename(staged, upload.path); err != nil {
+		os.Remove(staged)
+		return "", "", err
+	}
+	if !upload.modTime.IsZero() {
+		os.Chtimes(upload.path, upload.modTime, upload.modTime)
+	}
+
+	fmt.Printf("Synced %s into %s\n", upload.rel, upload.folder.Name)
+	return outcomeReceived, upload.rel, nil
+}
+
+// handleSync syncs a local folder to a sync folder on another device
+func (s *HTTPServer) handleSync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		SendTarget
+		Source string `json:"source"`
+		Folder string `json:"folder"`
+		Delete bool   `json:"delete"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.Group != "" {
+		http.Error(w, "Folders can only be synced to a single device", http.StatusBadRequest)
+		return
+	}
+	if request.Folder == "" {
+		http.Error(w, "Missing folder", http.StatusBadRequest)
+		return
+	}
+	if info, err := os.Stat(request.Source); !filepath.IsAbs(request.Source) || err != nil || !info.IsDir() {
+		http.Error(w, "Source must be the absolute path of a folder", http.StatusBadRequest)
+		return
+	}
+
+	target, err := s.resolveName(request.SendTarget)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result := s.syncToPeer(request.Source, target.host(), target.TargetPort, request.Folder, request.Delete)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// syncToPeer makes the sync folder on a peer a copy of source: the peer
+// compares the manifest of source with its folder, and the files it lacks or
+// has in another version are uploaded. With remove, the peer deletes files
+// that are not in source.
+func (s *HTTPServer) syncToPeer(source, targetHost string, targetPort int, folder string, remove bool) *SyncResult {
+	result := &SyncResult{Uploaded: []string{}, Deleted: []string{}, Errors: []string{}}
+	fail := func(err error) *SyncResult {
+		fmt.Printf("Error syncing %s to %s: %v\n", source, targetHost, err)
+		result.Errors = append(result.Errors, err.Error())
+		return result
+	}
+
+	// Peers that couldn't be asked count as protocol 1, which has no sync
+	info := s.discoveryService.PeerInfo(targetHost, targetPort)
+	if !discovery.HasCapability(info.Capabilities, discovery.CapSync) {
+		return fail(fmt.Errorf("peer does not support folder sync"))
+	}
+
+	files, err := listSyncFolder(source)
+	if err != nil {
+		return fail(err)
+	}
+	byPath := make(map[string]SyncFile, len(files))
+	for i, file := range files {
+		files[i].Hash, err = s.hashes.hash(filepath.Join(source, filepath.FromSlash(file.Path)), file.Size, file.ModTime)
+		if err != nil {
+			return fail(err)
+		}
+		byPath[file.Path] = files[i]
+	}
+
+	var needed, deleted []string
+	err = retryBusy(func() error {
+		needed, deleted, err = s.exchangeManifest(targetHost, targetPort, folder, files, remove)
+		return err
+	})
+	if err != nil {
+		return fail(err)
+	}
+	result.Deleted = deleted
+	result.Unchanged = len(files) - len(needed)
+
+	compression := ""
+	if discovery.HasCapability(info.Capabilities, discovery.CapCompression) {
+		compression = encodingGzip
+	}
+
+	target := net.JoinHostPort(targetHost, strconv.Itoa(targetPort))
+	var upload []SyncFile
+	for _, p := range needed {
+		file, ok := byPath[p]
+		if !ok {
+			result.Errors = append(result.Errors, fmt.Sprintf("peer asked for unknown file %s", p))
+			continue
+		}
+		key := refusalKey(target, folder, file)
+		if message, ok := s.syncRefused.get(key); ok {
+			result.Errors = append(result.Errors, fmt.Sprintf("Not sending %s again: %s", file.Path, message))
+			continue
+		}
+		upload = append(upload, file)
+	}
+
+	var mutex sync.Mutex
+	s.eachFile(len(upload), func(i int) {
+		file := upload[i]
+		err := retryBusy(func() error {
+			return s.uploadSynced(targetHost, targetPort, folder, source, file, compression)
+		})
+		if refused, ok := err.(*refusalError); ok && (refused.Reason == reasonFileTypeDenied || refused.Reason == reasonQuarantined) {
+			s.syncRefused.add(refusalKey(target, folder, file), refused.Error())
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("Failed to send %s: %v", file.Path, err))
+			return
+		}
+		result.Uploaded = append(result.Uploaded, file.Path)
+	})
+
+	sort.Strings(result.Uploaded)
+	result.Success = len(result.Errors) == 0
+	fmt.Printf("Synced %s to %s:%s (%d uploaded, %d deleted, %d unchanged, success: %v)\n",
+		source, net.JoinHostPort(targetHost, strconv.Itoa(targetPort)), folder,
+		len(result.Uploaded), len(result.Deleted), result.Unchanged, result.Success)
+	return result
+}
+
+// exchangeManifest sends the manifest of files to the peer and returns the
+// paths it needs and the paths it deleted
+func (s *HTTPServer) exchangeManifest(targetHost string, targetPort int, folder string, files []SyncFile, remove bool) ([]string, []string, error) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"folder": folder,
+		"files":  files,
+		"delete": remove,
+	})
+	req, err := http.NewRequest(http.MethodPost, peerURL(targetHost, targetPort, "/sync/manifest"), bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(senderHeader, s.discoveryService.DeviceName())
+	s.discoveryService.SignRequest(req, s.discoveryService.DeviceName())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, refusal(resp)
+	}
+
+	var answer struct {
+		Needed  []string `json:"needed"`
+		Deleted []string `json:"deleted"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		return nil, nil, fmt.Errorf("invalid response: %v", err)
+	}
+	if answer.Deleted == nil {
+		answer.Deleted = []string{}
+	}
+	return answer.Needed, answer.Deleted, nil
+}
+
+// uploadSynced sends a file of source to /upload, into the peer's sync folder
+func (s *HTTPServer) uploadSynced(targetHost string, targetPort int, folder, source string, file SyncFile, compression string) error {
+	f, err := os.Open(filepath.Join(source, filepath.FromSlash(file.Path)))
+	if err != nil {
+		return fmt.Errorf("failed to open file: %v", err)
+	}
+	defer f.Close()
+
+	result := &TransferResult{File: path.Base(file.Path), Bytes: file.Size}
+	if compression != "" && shouldCompress(f, file.Size) {
+		result.Encoding = compression
+	}
+
+	stream, err := s.newUploadStream(targetHost, targetPort, result, false)
+	if err != nil {
+		return err
+	}
+	stream.req.Header.Set(syncFolderHeader, folder)
+	stream.req.Header.Set(syncPathHeader, url.PathEscape(file.Path))
+	stream.req.Header.Set(modTimeHeader, file.ModTime.Format(time.RFC3339Nano))
+
+	go func() {
+		_, err := io.Copy(stream, f)
+		stream.finish(err)
+	}()
+	return stream.do(s.client)
+}
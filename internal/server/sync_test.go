@@ -0,0 +1,52 @@
+package server
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCleanSyncPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+		ok   bool
+	}{
+		{"readme.txt", "readme.txt", true},
+		{"img/hero.png", filepath.Join("img", "hero.png"), true},
+		{"img//hero.png", filepath.Join("img", "hero.png"), true},
+		{"img/./hero.png", filepath.Join("img", "hero.png"), true},
+		{"img/old/../hero.png", filepath.Join("img", "hero.png"), true},
+
+		// Paths can't leave the folder
+		{"../secret.txt", "secret.txt", true},
+		{"../../etc/passwd", filepath.Join("etc", "passwd"), true},
+		{"img/../../secret.txt", "secret.txt", true},
+		{"/etc/passwd", filepath.Join("etc", "passwd"), true},
+
+		// Nothing left to name a file
+		{"", "", false},
+		{".", "", false},
+		{"..", "", false},
+		{"/", "", false},
+		{"img/..", "", false},
+
+		// Backslashes are refused rather than taken as separators
+		{`img\hero.png`, "", false},
+		{`..\secret.txt`, "", false},
+		{`img/a\b/hero.png`, "", false},
+
+		// Hidden files and folders are not synced
+		{".env", "", false},
+		{".git/config", "", false},
+		{"img/.cache/hero.png", "", false},
+		{"img/.hero.png", "", false},
+		{".localsend-incoming/part", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := cleanSyncPath(tt.path)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("cleanSyncPath(%q) = %q, %v; want %q, %v", tt.path, got, ok, tt.want, tt.ok)
+		}
+	}
+}
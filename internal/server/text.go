@@ -0,0 +1,241 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"localsend/internal/discovery"
+)
+
+// maxTextSize limits a text message; longer texts should be sent as files
+const maxTextSize = 64 << 10
+
+// textHistory is how many received texts are kept
+const textHistory = 100
+
+// TextMessage is a text snippet received from a peer
+type TextMessage struct {
+	ID     string       `json:"id"`
+	Text   string       `json:"text"`
+	Sender *InboxSender `json:"sender"`
+}
+
+// textInbox keeps the most recently received texts, newest first
+type textInbox struct {
+	messages []*TextMessage
+	mutex    sync.Mutex
+}
+
+// add stores a received text
+func (ti *textInbox) add(msg *TextMessage) {
+	ti.mutex.Lock()
+	defer ti.mutex.Unlock()
+
+	ti.messages = append([]*TextMessage{msg}, ti.messages...)
+	if len(ti.messages) > textHistory {
+		ti.messages = ti.messages[:textHistory]
+	}
+}
+
+// list returns the received texts, newest first
+func (ti *textInbox) list() []*TextMessage {
+	ti.mutex.Lock()
+	defer ti.mutex.Unlock()
+
+	return append([]*TextMessage{}, ti.messages...)
+}
+
+// remove deletes a received text and reports whether it existed
+func (ti *textInbox) remove(id string) bool {
+	ti.mutex.Lock()
+	defer ti.mutex.Unlock()
+
+	for i, msg := range ti.messages {
+		if msg.ID == id {
+			ti.messages = append(ti.messages[:i], ti.messages[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// handleReceiveText receives a text message from another device, under the
+// same policy as files. The response acknowledges delivery of the message ID;
+// a message sent again with the same ID is not shown twice.
+func (s *HTTPServer) handleReceiveText(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	peer, ok := s.checkPolicy(w, r, r.Header.Get(senderHeader))
+	if !ok {
+		return
+	}
+
+	var request struct {
+		ID   string `json:"id"`
+		Text string `json:"text"`
+		Port int    `json:"port"` // where the sender receives replies
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxTextSize+1024)
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(request.Text) == "" || len(request.Text) > maxTextSize {
+		http.Error(w, fmt.Sprintf("Text must be between 1 and %d bytes", maxTextSize), http.StatusBadRequest)
+		return
+	}
+
+	sender := senderFromRequest(r)
+	sender.Fingerprint = peer.Fingerprint
+	if request.ID == "" {
+		request.ID = newChatID()
+	}
+
+	fresh := s.chats.record(senderPeer(sender, request.Port), &ChatEntry{
+		ID:     request.ID,
+		Kind:   chatText,
+		Text:   request.Text,
+		Status: outcomeReceived,
+		Time:   sender.ReceivedAt,
+	})
+	if fresh {
+		s.texts.add(&TextMessage{ID: request.ID, Text: request.Text, Sender: sender})
+		fmt.Printf("Received text from %s (%s, %d bytes)\n", sender.Name, sender.IP, len(request.Text))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"id":      request.ID,
+	})
+}
+
+// handleSendText sends a text message to a target device and adds it to the
+// conversation with that device. Sending again with the ID of a failed
+// message retries it.
+func (s *HTTPServer) handleSendText(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		SendTarget
+		ID   string `json:"id"`
+		Text string `json:"text"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(request.Text) == "" || len(request.Text) > maxTextSize {
+		http.Error(w, fmt.Sprintf("Text must be between 1 and %d bytes", maxTextSize), http.StatusBadRequest)
+		return
+	}
+
+	entry := &ChatEntry{
+		ID:       request.ID,
+		Outgoing: true,
+		Kind:     chatText,
+		Text:     request.Text,
+		Status:   chatDelivered,
+		Time:     time.Now(),
+	}
+	if entry.ID == "" {
+		entry.ID = newChatID()
+	}
+
+	response := map[string]interface{}{"success": true}
+	if err := s.sendText(request.host(), request.TargetPort, entry.ID, request.Text); err != nil {
+		fmt.Printf("Error sending text to %s: %v\n", request.host(), err)
+		entry.Status, entry.Error = chatFailed, err.Error()
+		response["success"] = false
+		response["error"] = err.Error()
+		if refused, ok := err.(*refusalError); ok {
+			response["reason"] = refused.Reason
+		}
+	}
+	s.chats.record(s.targetPeer(request.host(), request.TargetPort), entry)
+	response["message"] = entry
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// sendText posts text to the peer's /text endpoint and waits for it to
+// acknowledge the message ID
+func (s *HTTPServer) sendText(targetHost string, targetPort int, id, text string) error {
+	// Peers that couldn't be asked count as protocol 1, which has no text
+	info := s.discoveryService.PeerInfo(targetHost, targetPort)
+	if !discovery.HasCapability(info.Capabilities, discovery.CapText) {
+		return fmt.Errorf("peer does not accept text messages")
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{"id": id, "text": text, "port": s.port})
+	req, err := http.NewRequest(http.MethodPost, peerURL(targetHost, targetPort, "/text"), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(senderHeader, s.discoveryService.DeviceName())
+	s.discoveryService.SignRequest(req, s.discoveryService.DeviceName())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return refusal(resp)
+	}
+
+	var ack struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&ack); err != nil || ack.ID != id {
+		return fmt.Errorf("peer did not acknowledge the message")
+	}
+	return nil
+}
+
+// handleTexts lists received texts
+func (s *HTTPServer) handleTexts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"texts":   s.texts.list(),
+	})
+}
+
+// handleText deletes a received text with DELETE /api/texts/{id}
+func (s *HTTPServer) handleText(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.texts.remove(strings.TrimPrefix(r.URL.Path, "/api/texts/")) {
+		http.Error(w, "Text not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
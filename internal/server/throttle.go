@@ -0,0 +1,120 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"localsend/internal/config"
+)
+
+// BandwidthLimits are the transfer rate limits in KB per second, 0 meaning
+// no limit
+type BandwidthLimits struct {
+	Upload       int `json:"upload"`
+	Download     int `json:"download"`
+	PeerUpload   int `json:"peerUpload"`
+	PeerDownload int `json:"peerDownload"`
+}
+
+// kilobytes converts a configured rate in KB per second to bytes
+func kilobytes(kb int) int64 {
+	return int64(kb) << 10
+}
+
+// limitsFromConfig returns the configured bandwidth limits
+func limitsFromConfig(c *config.Config) BandwidthLimits {
+	return BandwidthLimits{
+		Upload:       c.UploadLimitKBps,
+		Download:     c.DownloadLimitKBps,
+		PeerUpload:   c.PeerUploadLimitKBps,
+		PeerDownload: c.PeerDownloadLimitKBps,
+	}
+}
+
+// applyLimits sets the throttles to limits
+func (s *HTTPServer) applyLimits(limits BandwidthLimits) {
+	s.uploads.SetRates(kilobytes(limits.Upload), kilobytes(limits.PeerUpload))
+	s.downloads.SetRates(kilobytes(limits.Download), kilobytes(limits.PeerDownload))
+}
+
+// throttleBody limits how fast the body of a transfer from a peer is read
+func (s *HTTPServer) throttleBody(r *http.Request) {
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{s.downloads.Reader(senderFromRequest(r).IP, r.Body), r.Body}
+}
+
+// throttleResponse limits how fast a download is sent to the requester. It
+// also hides the connection from ServeContent, which would otherwise copy
+// files to it with sendfile, bypassing the limit.
+func (s *HTTPServer) throttleResponse(w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	return throttledResponse{ResponseWriter: w, writer: s.uploads.Writer(senderFromRequest(r).IP, w)}
+}
+
+// throttledResponse writes the body of a response through a throttle
+type throttledResponse struct {
+	http.ResponseWriter
+	writer io.Writer
+}
+
+func (tr throttledResponse) Write(p []byte) (int, error) {
+	return tr.writer.Write(p)
+}
+
+// handleLimits reports the bandwidth limits, and changes them with a POST of
+// the fields to update
+func (s *HTTPServer) handleLimits(w http.ResponseWriter, r *http.Request) {
+	var limits BandwidthLimits
+	switch r.Method {
+	case http.MethodGet:
+		s.config.View(func(c *config.Config) {
+			limits = limitsFromConfig(c)
+		})
+	case http.MethodPost:
+		var request struct {
+			Upload       *int `json:"upload"`
+			Download     *int `json:"download"`
+			PeerUpload   *int `json:"peerUpload"`
+			PeerDownload *int `json:"peerDownload"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		for _, v := range []*int{request.Upload, request.Download, request.PeerUpload, request.PeerDownload} {
+			if v != nil && *v < 0 {
+				http.Error(w, "Limits must not be negative", http.StatusBadRequest)
+				return
+			}
+		}
+
+		err := s.config.Update(func(c *config.Config) {
+			set := func(dst *int, v *int) {
+				if v != nil {
+					*dst = *v
+				}
+			}
+			set(&c.UploadLimitKBps, request.Upload)
+			set(&c.DownloadLimitKBps, request.Download)
+			set(&c.PeerUploadLimitKBps, request.PeerUpload)
+			set(&c.PeerDownloadLimitKBps, request.PeerDownload)
+			limits = limitsFromConfig(c)
+			s.applyLimits(limits)
+		})
+		if err != nil {
+			fmt.Printf("Error saving bandwidth limits: %v\n", err)
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"limits":  limits,
+	})
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"localsend/internal/discovery"
+)
+
+// Transfer modes
+const (
+	modeParallel  = "parallel"  // large files in ranges over several connections
+	modeRaw       = "raw"       // POST /upload/raw, the file as the body, sent with sendfile
+	modeMultipart = "multipart" // POST /upload, understood by every version
+)
+
+// transferModes lists upload modes from most to least preferred, with the
+// capability the receiver must announce for each
+var transferModes = []struct {
+	name       string
+	capability string
+}{
+	{modeParallel, discovery.CapParallel},
+	{modeRaw, discovery.CapRaw},
+	{modeMultipart, ""},
+}
+
+// features are capabilities supported independently of the transfer mode
+var features = []string{discovery.CapCompression, discovery.CapText, discovery.CapSync}
+
+// transferMode is the way files are sent to a particular peer
+type transferMode struct {
+	Name        string
+	Compression string // content encoding the peer accepts, "" for none
+	Peer        *discovery.Info
+}
+
+// supports reports whether the peer announced capability
+func (m *transferMode) supports(capability string) bool {
+	return discovery.HasCapability(m.Peer.Capabilities, capability)
+}
+
+// TransferResult describes one file sent to a peer
+type TransferResult struct {
+	File      string  `json:"file"`
+	Bytes     int64   `json:"bytes"`              // size of the file
+	WireBytes int64   `json:"wireBytes"`          // bytes sent over the network
+	Encoding  string  `json:"encoding,omitempty"` // content encoding used, if any
+	Ratio     float64 `json:"ratio"`              // Bytes / WireBytes
+	Mode      string  `json:"mode"`               // transfer mode used
+	Streams   int     `json:"streams"`            // connections used
+	Attempts  int     `json:"attempts,omitempty"` // tries when sent to several peers
+	Error     string  `json:"error,omitempty"`
+}
+
+// measure computes the compression ratio of a sent file
+func (r *TransferResult) measure() {
+	if r.WireBytes > 0 {
+		r.Ratio = float64(r.Bytes) / float64(r.WireBytes)
+	}
+}
+
+// capabilities returns what this instance announces to peers
+func capabilities() []string {
+	caps := append([]string{}, features...)
+	for _, mode := range transferModes {
+		if mode.capability != "" && !discovery.HasCapability(caps, mode.capability) {
+			caps = append(caps, mode.capability)
+		}
+	}
+	return caps
+}
+
+// negotiateTransfer picks the best mode both this instance and the peer support
+func (s *HTTPServer) negotiateTransfer(host string, port int) *transferMode {
+	peer := s.discoveryService.PeerInfo(host, port)
+
+	compression := ""
+	if discovery.HasCapability(peer.Capabilities, discovery.CapCompression) {
+		compression = encodingGzip
+	}
+
+	for _, mode := range transferModes {
+		if mode.capability == "" || discovery.HasCapability(peer.Capabilities, mode.capability) {
+			fmt.Printf("Sending to %s using %s (protocol %d)\n", host, mode.name, peer.Version)
+			return &transferMode{Name: mode.name, Compression: compression, Peer: peer}
+		}
+	}
+	return &transferMode{Name: modeMultipart, Compression: compression, Peer: peer}
+}
+
+// newTransferClient returns the client used to send files. It has no overall
+// timeout, which would cut off large transfers, and keeps enough idle
+// connections for parallel streams.
+func newTransferClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   10 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConnsPerHost:   maxStreams * 2,
+			WriteBufferSize:       256 << 10, // for multipart and compressed bodies
+			ReadBufferSize:        64 << 10,
+			IdleConnTimeout:       90 * time.Second,
+			ResponseHeaderTimeout: 30 * time.Second,
+		},
+	}
+}
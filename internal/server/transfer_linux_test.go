@@ -0,0 +1,105 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"localsend/internal/config"
+	"localsend/internal/discovery"
+)
+
+// benchFileSize is large enough for the parallel mode to split the file
+const benchFileSize = 256 << 20
+
+// newBenchServer starts a receiving peer on a loopback listener and returns
+// a server to send from, the peer's host and port, and a file to send
+func newBenchServer(b *testing.B) (*HTTPServer, string, int, string) {
+	_, identity, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	dir := b.TempDir()
+	cfg := &config.Config{
+		DeviceName:          "bench",
+		DownloadDir:         filepath.Join(dir, "downloads"),
+		Identity:            identity,
+		MaxTransfers:        64,
+		MaxTransfersPerPeer: 64,
+		ParallelFiles:       1,
+	}
+	os.MkdirAll(cfg.DownloadDir, 0755)
+
+	s := NewHTTPServer(cfg, discovery.NewService(cfg))
+	peer := httptest.NewServer(s.peerMux())
+	b.Cleanup(peer.Close)
+
+	host, portStr, _ := net.SplitHostPort(peer.Listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+
+	// Random data, so that no mode compresses it
+	path := filepath.Join(dir, "payload.bin")
+	data := make([]byte, benchFileSize)
+	rand.Read(data)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		b.Fatal(err)
+	}
+
+	return s, host, port, path
+}
+
+// cpuTime returns the user and system CPU time used by this process
+func cpuTime() time.Duration {
+	var usage syscall.Rusage
+	syscall.Getrusage(syscall.RUSAGE_SELF, &usage)
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
+
+// benchmarkMode sends the payload to a loopback peer that only announces
+// capability, reporting throughput and the CPU time both sides spent per file
+func benchmarkMode(b *testing.B, capability string) {
+	s, host, port, path := newBenchServer(b)
+	mode := &transferMode{
+		Name: capability,
+		Peer: &discovery.Info{Port: port, Version: 2, Capabilities: []string{capability}},
+	}
+
+	b.SetBytes(benchFileSize)
+	b.ResetTimer()
+	start := cpuTime()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := s.sendFileToDevice(host, port, path, mode); err != nil {
+			b.Fatal(err)
+		}
+
+		b.StopTimer()
+		entries, _ := os.ReadDir(s.downloadDir)
+		for _, entry := range entries {
+			os.RemoveAll(filepath.Join(s.downloadDir, entry.Name()))
+		}
+		b.StartTimer()
+	}
+
+	b.ReportMetric(float64((cpuTime()-start).Milliseconds())/float64(b.N), "cpu-ms/op")
+}
+
+func BenchmarkSendMultipart(b *testing.B) {
+	benchmarkMode(b, modeMultipart)
+}
+
+func BenchmarkSendRaw(b *testing.B) {
+	benchmarkMode(b, discovery.CapRaw)
+}
+
+func BenchmarkSendParallel(b *testing.B) {
+	benchmarkMode(b, discovery.CapParallel)
+}
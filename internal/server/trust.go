@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"localsend/internal/config"
+)
+
+// handleTrust shows this device's fingerprint and manages the paired devices
+func (s *HTTPServer) handleTrust(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		var trusted []config.TrustedPeer
+		s.config.View(func(c *config.Config) {
+			trusted = c.TrustedPeers
+		})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":     true,
+			"fingerprint": s.discoveryService.Fingerprint(),
+			"trusted":     trusted,
+		})
+	case http.MethodPost:
+		s.handleAddTrust(w, r)
+	case http.MethodDelete:
+		s.handleRemoveTrust(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAddTrust pairs with a device by the fingerprint of its key
+func (s *HTTPServer) handleAddTrust(w http.ResponseWriter, r *http.Request) {
+	var request config.TrustedPeer
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	fingerprint := strings.ToLower(strings.TrimSpace(request.Fingerprint))
+	if b, err := hex.DecodeString(fingerprint); err != nil || len(b) != 32 {
+		http.Error(w, "Invalid fingerprint", http.StatusBadRequest)
+		return
+	}
+
+	err := s.config.Update(func(c *config.Config) {
+		peers := make([]config.TrustedPeer, 0, len(c.TrustedPeers)+1)
+		for _, existing := range c.TrustedPeers {
+			if existing.Fingerprint != fingerprint {
+				peers = append(peers, existing)
+			}
+		}
+		c.TrustedPeers = append(peers, config.TrustedPeer{
+			Name:        strings.TrimSpace(request.Name),
+			Fingerprint: fingerprint,
+		})
+		s.discoveryService.SetTrustedPeers(c.TrustedPeers)
+	})
+	if err != nil {
+		fmt.Printf("Error saving trusted peers: %v\n", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
+
+// handleRemoveTrust unpairs a device
+func (s *HTTPServer) handleRemoveTrust(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Fingerprint string `json:"fingerprint"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	found := false
+	err := s.config.Update(func(c *config.Config) {
+		peers := make([]config.TrustedPeer, 0, len(c.TrustedPeers)+1)
+		for _, existing := range c.TrustedPeers {
+			if existing.Fingerprint == strings.ToLower(request.Fingerprint) {
+				found = true
+				continue
+			}
+			peers = append(peers, existing)
+		}
+		c.TrustedPeers = peers
+		s.discoveryService.SetTrustedPeers(c.TrustedPeers)
+	})
+	if err != nil {
+		fmt.Printf("Error saving trusted peers: %v\n", err)
+	}
+	if !found {
+		http.Error(w, "Fingerprint not trusted", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
@@ -0,0 +1,410 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"localsend/internal/config"
+)
+
+// Files of watch folders are sent one batch at a time; a failed file is
+// retried after watchRetryDelay, doubling up to watchMaxRetryDelay
+const (
+	watchQueueSize     = 64
+	watchRetryDelay    = time.Minute
+	watchMaxRetryDelay = time.Hour
+)
+
+// watchedFile is a file seen in a watch folder
+type watchedFile struct {
+	size     int64
+	modTime  time.Time
+	queued   bool
+	attempts int
+	retryAt  time.Time
+	err      string
+	// delivered holds the targets that received this version of the file
+	delivered map[string]bool
+}
+
+// watchRule is a watch folder with the files seen in it
+type watchRule struct {
+	config.WatchFolder
+	target   SendTarget
+	files    map[string]*watchedFile // by name
+	sent     int
+	lastSent time.Time
+	err      string // why the folder can't be read
+}
+
+// watchJob sends files of a watch folder, skipping targets that already
+// received them
+type watchJob struct {
+	rule      *watchRule
+	names     []string
+	delivered map[string]bool
+}
+
+// folderWatcher polls the watch folders and sends their complete files
+// through a queue, one job at a time
+type folderWatcher struct {
+	server *HTTPServer
+	rules  []*watchRule
+	poll   time.Duration
+	settle time.Duration
+	jobs   chan *watchJob
+	stop   chan struct{}
+	mutex  sync.Mutex
+}
+
+// newFolderWatcher checks the configured watch folders, skipping invalid ones
+func newFolderWatcher(s *HTTPServer, cfg *config.Config) *folderWatcher {
+	w := &folderWatcher{
+		server: s,
+		poll:   time.Duration(cfg.WatchPollSeconds) * time.Second,
+		settle: time.Duration(cfg.WatchSettleSeconds) * time.Second,
+		jobs:   make(chan *watchJob, watchQueueSize),
+		stop:   make(chan struct{}),
+	}
+	if w.poll <= 0 {
+		w.poll = 2 * time.Second
+	}
+
+	for _, folder := range cfg.WatchFolders {
+		path, err := filepath.Abs(folder.Path)
+		if err == nil {
+			folder.Path = path
+			err = os.MkdirAll(path, 0755)
+		}
+		if err != nil {
+			fmt.Printf("Error watching %s: %v\n", folder.Path, err)
+			continue
+		}
+		if folder.AfterSend == "" {
+			folder.AfterSend = config.AfterSendMove
+		}
+		if folder.AfterSend != config.AfterSendMove && folder.AfterSend != config.AfterSendDelete {
+			fmt.Printf("Error watching %s: afterSend must be %q or %q\n", path, config.AfterSendMove, config.AfterSendDelete)
+			continue
+		}
+		target, err := ParseTarget(folder.Target, cfg.HTTPPort)
+		if err != nil {
+			fmt.Printf("Error watching %s: %v\n", path, err)
+			continue
+		}
+		w.rules = append(w.rules, &watchRule{
+			WatchFolder: folder,
+			target:      target,
+			files:       make(map[string]*watchedFile),
+		})
+	}
+	return w
+}
+
+// run polls the watch folders until the watcher is stopped
+func (w *folderWatcher) run() {
+	if len(w.rules) == 0 {
+		return
+	}
+	for _, rule := range w.rules {
+		fmt.Printf("Watching %s, sending to %s\n", rule.Path, rule.Target)
+	}
+	go w.sendQueued()
+
+	ticker := time.NewTicker(w.poll)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, rule := range w.rules {
+				for _, job := range w.scan(rule) {
+					select {
+					case w.jobs <- job:
+					case <-w.stop:
+						return
+					}
+				}
+			}
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// Stop stops polling and sending
+func (w *folderWatcher) Stop() {
+	close(w.stop)
+}
+
+// scan returns jobs for the files of the folder that are ready to be sent:
+// new files that haven't changed since the last scan nor for the settle
+// time, and failed files due for a retry
+func (w *folderWatcher) scan(rule *watchRule) []*watchJob {
+	entries, err := os.ReadDir(rule.Path)
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if err != nil {
+		if rule.err != err.Error() {
+			fmt.Printf("Error reading watch folder %s: %v\n", rule.Path, err)
+		}
+		rule.err = err.Error()
+		return nil
+	}
+	rule.err = ""
+
+	now := time.Now()
+	seen := make(map[string]bool)
+	var fresh []string
+	var jobs []*watchJob
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || strings.HasPrefix(name, ".") || !rule.Matches(name) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		seen[name] = true
+
+		file := rule.files[name]
+		if file == nil || (!file.queued && (file.size != info.Size() || !file.modTime.Equal(info.ModTime()))) {
+			// New or still being written
+			rule.files[name] = &watchedFile{size: info.Size(), modTime: info.ModTime()}
+			continue
+		}
+		if file.queued || now.Sub(file.modTime) < w.settle || now.Before(file.retryAt) {
+			continue
+		}
+
+		file.queued = true
+		if len(file.delivered) == 0 {
+			fresh = append(fresh, name)
+			continue
+		}
+		delivered := make(map[string]bool)
+		for key := range file.delivered {
+			delivered[key] = true
+		}
+		jobs = append(jobs, &watchJob{rule: rule, names: []string{name}, delivered: delivered})
+	}
+
+	for name, file := range rule.files {
+		if !seen[name] && !file.queued {
+			delete(rule.files, name)
+		}
+	}
+
+	if len(fresh) > 0 {
+		jobs = append([]*watchJob{{rule: rule, names: fresh}}, jobs...)
+	}
+	return jobs
+}
+
+// sendQueued sends queued jobs one at a time
+func (w *folderWatcher) sendQueued() {
+	for {
+		select {
+		case job := <-w.jobs:
+			w.send(job)
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// send sends the files of a job to the folder's target and records which
+// devices received each file
+func (w *folderWatcher) send(job *watchJob) {
+	paths := make([]string, len(job.names))
+	for i, name := range job.names {
+		paths[i] = filepath.Join(job.rule.Path, name)
+	}
+	errs := make([][]string, len(paths))
+	received := make([][]string, len(paths))
+
+	failAll := func(message string) {
+		for i := range errs {
+			errs[i] = append(errs[i], message)
+		}
+	}
+
+	targets, offline, err := w.server.expandTargets([]SendTarget{job.rule.target})
+	switch {
+	case err != nil:
+		failAll(err.Error())
+	case len(targets) == 0 && len(offline) == 0:
+		failAll("group has no members")
+	default:
+		var pending []SendTarget
+		for _, target := range targets {
+			if !job.delivered[sendTargetKey(target)] {
+				pending = append(pending, target)
+			}
+		}
+		var statuses []*TargetStatus
+		if len(pending) > 0 {
+			statuses = w.server.sendToTargets(pending, paths)
+		}
+		for _, status := range offline {
+			if !job.delivered[targetStatusKey(status)] {
+				statuses = append(statuses, status)
+			}
+		}
+
+		for _, status := range statuses {
+			for i := range paths {
+				if i < len(status.Results) && status.Results[i] != nil && status.Results[i].Error == "" {
+					received[i] = append(received[i], targetStatusKey(status))
+					continue
+				}
+				message := strings.Join(status.Errors, "; ")
+				if i < len(status.Results) && status.Results[i] != nil {
+					message = status.Results[i].Error
+				}
+				errs[i] = append(errs[i], fmt.Sprintf("%s: %s", status.Target, message))
+			}
+		}
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for i, name := range job.names {
+		w.finish(job.rule, name, received[i], errs[i])
+	}
+}
+
+// finish records the outcome of sending a file, and moves or deletes it once
+// every target received it. The caller must hold the mutex.
+func (w *folderWatcher) finish(rule *watchRule, name string, received, errs []string) {
+	file := rule.files[name]
+	file.queued = false
+	path := filepath.Join(rule.Path, name)
+
+	// A file changed while it was sent is sent again to every target
+	info, err := os.Stat(path)
+	if err != nil || info.Size() != file.size || !info.ModTime().Equal(file.modTime) {
+		delete(rule.files, name)
+		return
+	}
+
+	if file.delivered == nil {
+		file.delivered = make(map[string]bool)
+	}
+	for _, key := range received {
+		file.delivered[key] = true
+	}
+
+	if len(errs) == 0 {
+		err = afterSend(rule.WatchFolder, path)
+		if err == nil {
+			delete(rule.files, name)
+			rule.sent++
+			rule.lastSent = time.Now()
+			return
+		}
+		errs = []string{err.Error()}
+	}
+
+	delay := watchRetryDelay << file.attempts
+	if delay > watchMaxRetryDelay || delay <= 0 {
+		delay = watchMaxRetryDelay
+	}
+	file.attempts++
+	file.retryAt = time.Now().Add(delay)
+	file.err = strings.Join(errs, "; ")
+	fmt.Printf("Error sending %s from watch folder: %s (retrying in %v)\n", name, file.err, delay)
+}
+
+// afterSend moves or deletes a sent file
+func afterSend(folder config.WatchFolder, path string) error {
+	if folder.AfterSend == config.AfterSendDelete {
+		return os.Remove(path)
+	}
+	dir := folder.SentDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return os.Rename(path, uniquePath(filepath.Join(dir, filepath.Base(path))))
+}
+
+// sendTargetKey identifies the device of a target
+func sendTargetKey(target SendTarget) string {
+	if target.device != "" {
+		return target.device
+	}
+	return net.JoinHostPort(target.host(), strconv.Itoa(target.TargetPort))
+}
+
+// targetStatusKey identifies the device of a target status like sendTargetKey
+func targetStatusKey(status *TargetStatus) string {
+	if status.Device != "" {
+		return status.Device
+	}
+	return status.Target
+}
+
+// handleWatch lists the watch folders with the state of their files
+func (s *HTTPServer) handleWatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.watcher.mutex.Lock()
+	folders := make([]map[string]interface{}, 0, len(s.watcher.rules))
+	for _, rule := range s.watcher.rules {
+		waiting, queued := 0, 0
+		failed := []map[string]interface{}{}
+		for name, file := range rule.files {
+			switch {
+			case file.queued:
+				queued++
+			case file.err != "":
+				failed = append(failed, map[string]interface{}{
+					"file":    name,
+					"error":   file.err,
+					"retryAt": file.retryAt,
+				})
+			default:
+				waiting++
+			}
+		}
+		folder := map[string]interface{}{
+			"path":      rule.Path,
+			"target":    rule.Target,
+			"afterSend": rule.AfterSend,
+			"waiting":   waiting,
+			"queued":    queued,
+			"sent":      rule.sent,
+			"failed":    failed,
+		}
+		if !rule.lastSent.IsZero() {
+			folder["lastSent"] = rule.lastSent
+		}
+		if rule.err != "" {
+			folder["error"] = rule.err
+		}
+		folders = append(folders, folder)
+	}
+	s.watcher.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"folders": folders,
+	})
+}
@@ -0,0 +1,11 @@
+// Package version holds the application and protocol versions announced to peers.
+package version
+
+// App is the application version. Release builds may override it with
+// -ldflags "-X localsend/internal/version.App=x.y.z".
+var App = "2.0.0-dev"
+
+// Protocol is the discovery and transfer protocol version. Instances that
+// don't announce a version speak protocol 1: the original type/deviceName/ip/port
+// discovery messages and multipart uploads to /upload.
+const Protocol = 2
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/ed25519"
 	"fmt"
 	"log"
 	"os"
@@ -8,17 +9,24 @@ import (
 	"syscall"
 	"time"
 
+	"localsend/internal/config"
 	"localsend/internal/discovery"
 	"localsend/internal/server"
-	"localsend/internal/config"
 )
 
 func main() {
+	// Handle CLI commands before starting the services
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1], os.Args[2:])
+		return
+	}
+
 	// Load configuration
 	cfg := config.Load()
 
 	fmt.Printf("Starting LocalSend application...\n")
-	fmt.Printf("HTTP Server: http://localhost:%d\n", cfg.HTTPPort)
+	fmt.Printf("Admin Interface: %s\n", cfg.AdminAddr)
+	fmt.Printf("Transfer Port: %d\n", cfg.HTTPPort)
 	fmt.Printf("UDP Discovery Port: %d\n", cfg.UDPPort)
 
 	// Create channels for graceful shutdown
@@ -26,7 +34,7 @@ func main() {
 	signal.Notify(stopChan, os.Interrupt, syscall.SIGTERM)
 
 	// Start UDP discovery service
-	discoveryService := discovery.NewService(cfg.UDPPort, cfg.DeviceName)
+	discoveryService := discovery.NewService(cfg)
 	go func() {
 		if err := discoveryService.Start(); err != nil {
 			log.Printf("Discovery service error: %v", err)
@@ -34,7 +42,7 @@ func main() {
 	}()
 
 	// Start HTTP server
-	httpServer := server.NewHTTPServer(cfg.HTTPPort, cfg.DownloadDir, discoveryService)
+	httpServer := server.NewHTTPServer(cfg, discoveryService)
 	go func() {
 		if err := httpServer.Start(); err != nil {
 			log.Printf("HTTP server error: %v", err)
@@ -44,7 +52,7 @@ func main() {
 	// Wait for a few seconds to ensure services are running
 	time.Sleep(2 * time.Second)
 	fmt.Println("\nApplication is ready!")
-	fmt.Println("Open your browser and go to: http://localhost:8080")
+	fmt.Printf("Open your browser and go to: %s\n", httpServer.AdminURL())
 	fmt.Println("Press Ctrl+C to stop the application")
 
 	// Wait for shutdown signal
@@ -56,4 +64,35 @@ func main() {
 	httpServer.Stop()
 
 	fmt.Println("Application stopped.")
-}
\ No newline at end of file
+}
+
+// runCommand executes a CLI command instead of starting the application
+func runCommand(name string, args []string) {
+	switch name {
+	case "token":
+		// Print the control API token for scripted use, e.g.
+		// curl -H "X-LocalSend-Token: $(localsend token)" http://127.0.0.1:8081/api/peers
+		token, err := config.LoadToken()
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		fmt.Println(token)
+	case "fingerprint":
+		// Print the fingerprint of this device's key, to compare when pairing
+		key, err := config.LoadIdentity()
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		fmt.Println(discovery.Fingerprint(key.Public().(ed25519.PublicKey)))
+	case "send":
+		// Send files through the running application to devices or groups
+		runSend(args)
+	case "sync":
+		// Keep a folder on another device in sync with a local folder
+		runSync(args)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", name)
+		fmt.Fprintln(os.Stderr, "Usage: localsend [token|fingerprint|send|sync]")
+		os.Exit(2)
+	}
+}
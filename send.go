@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"localsend/internal/config"
+	"localsend/internal/server"
+)
+
+// targetFlags collects repeated --to flags
+type targetFlags []string
+
+func (t *targetFlags) String() string {
+	return strings.Join(*t, ",")
+}
+
+func (t *targetFlags) Set(value string) error {
+	*t = append(*t, value)
+	return nil
+}
+
+// runSend sends files through the running application, e.g.
+// localsend send --to @qa-rigs --to 10.8.0.5:8080 build.zip
+func runSend(args []string) {
+	var to targetFlags
+	flags := flag.NewFlagSet("send", flag.ExitOnError)
+	flags.Var(&to, "to", "device address (host or host:port) or @group; may be repeated")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: localsend send --to <address|@group> [--to ...] file...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if len(to) == 0 || flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	cfg := config.Load()
+
+	var targets []server.SendTarget
+	for _, value := range to {
+		target, err := server.ParseTarget(value, cfg.HTTPPort)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		targets = append(targets, target)
+	}
+
+	// The application reads the files itself, so it needs absolute paths
+	var filePaths []string
+	for _, name := range flags.Args() {
+		path, err := filepath.Abs(name)
+		if err == nil {
+			_, err = os.Stat(path)
+		}
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		filePaths = append(filePaths, path)
+	}
+
+	var result struct {
+		Success bool                   `json:"success"`
+		Targets []*server.TargetStatus `json:"targets"`
+	}
+	err := postAdmin(cfg, "/api/send", map[string]interface{}{
+		"targets":   targets,
+		"filePaths": filePaths,
+	}, &result)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	for _, status := range result.Targets {
+		label := status.Target
+		if status.Device != "" && status.Device != status.Target {
+			label += " (" + status.Device + ")"
+		}
+		if status.Success {
+			fmt.Printf("%s: sent %d files\n", label, len(status.Results))
+		} else {
+			fmt.Printf("%s: failed: %s\n", label, strings.Join(status.Errors, "; "))
+		}
+	}
+	if !result.Success {
+		os.Exit(1)
+	}
+}
+
+// postAdmin posts body to the control API of the running application and
+// decodes its answer into result
+func postAdmin(cfg *config.Config, path string, body interface{}, result interface{}) error {
+	data, _ := json.Marshal(body)
+	req, err := http.NewRequest(http.MethodPost, cfg.AdminURL()+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(config.TokenHeader, cfg.APIToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%v (is LocalSend running?)", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s", strings.TrimSpace(string(message)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("invalid response: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"localsend/internal/config"
+	"localsend/internal/server"
+)
+
+// runSync makes a folder on another device a copy of a local folder, e.g.
+// localsend sync ./assets --to designer-pc:assets
+func runSync(args []string) {
+	flags := flag.NewFlagSet("sync", flag.ExitOnError)
+	to := flags.String("to", "", "device address and sync folder, as host[:port]:folder")
+	remove := flags.Bool("delete", false, "delete files on the device that are not in the local folder")
+	watch := flags.Bool("watch", false, "keep syncing until interrupted")
+	interval := flags.Duration("interval", 10*time.Second, "time between syncs with --watch")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: localsend sync <folder> --to <host[:port]:folder> [--delete] [--watch [--interval 10s]]")
+		flags.PrintDefaults()
+	}
+
+	// Flags may follow the folder
+	var folders []string
+	flags.Parse(args)
+	for flags.NArg() > 0 {
+		folders = append(folders, flags.Arg(0))
+		flags.Parse(flags.Args()[1:])
+	}
+
+	i := strings.LastIndex(*to, ":")
+	if len(folders) != 1 || i <= 0 || i == len(*to)-1 || strings.HasPrefix(*to, "@") || *interval <= 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	cfg := config.Load()
+
+	target, err := server.ParseTarget((*to)[:i], cfg.HTTPPort)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	source, err := filepath.Abs(folders[0])
+	if err == nil {
+		_, err = os.Stat(source)
+	}
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	request := map[string]interface{}{
+		"targetIP":   target.TargetIP,
+		"targetZone": target.TargetZone,
+		"targetPort": target.TargetPort,
+		"source":     source,
+		"folder":     (*to)[i+1:],
+		"delete":     *remove,
+	}
+
+	for {
+		var result server.SyncResult
+		err := postAdmin(cfg, "/api/sync", request, &result)
+		switch {
+		case err != nil && !*watch:
+			log.Fatalf("Error: %v", err)
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		default:
+			printSync(&result, !*watch)
+		}
+
+		if !*watch {
+			if !result.Success {
+				os.Exit(1)
+			}
+			return
+		}
+		time.Sleep(*interval)
+	}
+}
+
+// printSync prints the changes made by a sync, and a summary if anything
+// changed or verbose is set
+func printSync(result *server.SyncResult, verbose bool) {
+	for _, path := range result.Uploaded {
+		fmt.Printf("+ %s\n", path)
+	}
+	for _, path := range result.Deleted {
+		fmt.Printf("- %s\n", path)
+	}
+	for _, message := range result.Errors {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", message)
+	}
+	if verbose || len(result.Uploaded) > 0 || len(result.Deleted) > 0 {
+		fmt.Printf("%d uploaded, %d deleted, %d unchanged\n", len(result.Uploaded), len(result.Deleted), result.Unchanged)
+	}
+}